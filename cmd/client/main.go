@@ -2,22 +2,46 @@ package main
 
 import (
 	"bufio"
+	"crypto/tls"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"time"
 	"zenquote/api"
+	"zenquote/internal/framing"
 	"zenquote/internal/pow"
+	"zenquote/internal/tlsconfig"
 
 	"google.golang.org/protobuf/proto"
 )
 
 const (
-	maxRequestSize = 1024
+	maxRequestSize  = 1024
+	maxResponseSize = 64 << 10
+	protocolVersion = 1
+	serverAddr      = "server:8080"
+	helloTimeout    = 5 * time.Second
+)
+
+var (
+	quoteCount = flag.Int("quotes", 1, "number of quotes to get, the credit of a solved challenge pays for the next ones")
+
+	useTLS        = flag.Bool("tls", false, "connect with TLS")
+	tlsCA         = flag.String("tls-ca", "", "PEM file of the CA of the server certificate, the system CAs if empty")
+	tlsCert       = flag.String("tls-cert", "", "PEM file of the client certificate, for servers requiring mutual TLS")
+	tlsKey        = flag.String("tls-key", "", "PEM file of the client certificate key")
+	tlsServerName = flag.String("tls-server-name", "", "name the server certificate is checked against, the host if empty")
+
+	errCreditInvalid = errors.New("credit not accepted")
+	errNoHello       = errors.New("no hello answer")
 )
 
 func main() {
-	conn, err := connectToServer()
+	filter := parseQuoteFilter()
+
+	conn, codec, err := connect()
 	if err != nil {
 		log.Fatalf("Failed to connect to server: %v", err)
 	}
@@ -26,34 +50,134 @@ func main() {
 		_ = conn.Close()
 	}()
 
-	reader := bufio.NewReader(conn)
+	// The first quote is paid for with a solved challenge, the next ones with its credit while it lasts
+	var credit string
+
+	for i := 0; i < *quoteCount; i++ {
+		var resp *api.Response
+
+		if credit != "" {
+			resp, err = getQuote(codec, credit, filter)
+			if err != nil && !errors.Is(err, errCreditInvalid) {
+				log.Panicf("failed to get quote: %v", err)
+			}
+		}
+
+		if resp == nil {
+			if resp, err = solve(codec, filter); err != nil {
+				log.Panicf("failed to get quote for a solved challenge: %v", err)
+			}
+		}
+
+		credit = ""
+		if resp.GetCredit().GetRemaining() != 0 {
+			credit = resp.GetCredit().GetToken()
+		}
+
+		if filter != nil && !resp.GetFiltered() {
+			fmt.Println("No quote matches the filter, here is a random one.")
+		}
+
+		printQuote(resp)
+	}
+}
 
-	// Request challenge from server
-	challenge, err := getChallenge(reader, conn)
+// solve gets a challenge, solves it and returns the response to the solution.
+func solve(codec framing.Codec, filter *api.QuoteFilter) (*api.Response, error) {
+	challenge, challengeID, err := getChallenge(codec)
 	if err != nil {
-		log.Panicf("failed to get challenge: %v", err)
+		return nil, err
 	}
 
-	hashcash, err := pow.NewHashcashFromString(challenge)
+	puzzle, err := pow.ParsePuzzle(challenge)
 	if err != nil {
-		log.Panicf("failed to create hashcash from string: %v", err)
+		return nil, fmt.Errorf("failed to parse puzzle: %w", err)
+	}
+
+	if err = puzzle.SolveChallenge(); err != nil {
+		return nil, fmt.Errorf("failed to solve challenge: %w", err)
 	}
 
-	// Solve the challenge
-	err = hashcash.SolveChallenge()
+	return sendSolution(codec, puzzle.ToString(), challengeID, filter)
+}
+
+// parseQuoteFilter returns the quote filter given with the command line flags, nil for a random quote.
+func parseQuoteFilter() *api.QuoteFilter {
+	filter := &api.QuoteFilter{}
+
+	flag.StringVar(&filter.Author, "author", "", "ask for a quote by this author")
+	flag.StringVar(&filter.Tag, "tag", "", "ask for a quote with this tag")
+	flag.StringVar(&filter.Keyword, "keyword", "", "ask for a quote containing this word")
+	flag.StringVar(&filter.Language, "language", "", "ask for a quote in this language")
+	flag.BoolVar(&filter.Daily, "daily", false, "ask for the quote of the day")
+	flag.Parse()
+
+	if filter.GetAuthor() == "" && filter.GetTag() == "" && filter.GetKeyword() == "" &&
+		filter.GetLanguage() == "" && !filter.GetDaily() {
+		return nil
+	}
+
+	return filter
+}
+
+// connect opens a session negotiated with HELLO. A server older than the framing preamble waits for
+// a newline that never comes or answers in a framing the client can not read, so when the HELLO
+// answer is missing the client connects again and talks to it with the legacy newline framing.
+func connect() (net.Conn, framing.Codec, error) {
+	conn, err := connectToServer()
 	if err != nil {
-		log.Panicf("failed to solve challenge: %v", err)
+		return nil, nil, err
 	}
 
-	// Send solved challenge
-	err = sendSolution(reader, conn, hashcash.ToString())
+	codec, err := negotiate(conn)
+	if err == nil {
+		return conn, codec, nil
+	}
+
+	_ = conn.Close()
+
+	if !errors.Is(err, errNoHello) {
+		return nil, nil, err
+	}
+
+	log.Printf("server did not answer HELLO, falling back to the legacy protocol: %v", err)
+
+	if conn, err = connectToServer(); err != nil {
+		return nil, nil, err
+	}
+
+	return conn, framing.NewLineCodec(bufio.NewReader(conn), conn, maxResponseSize), nil
+}
+
+// negotiate sends the preamble and HELLO, the server has helloTimeout to answer.
+func negotiate(conn net.Conn) (framing.Codec, error) {
+	if err := conn.SetDeadline(time.Now().Add(helloTimeout)); err != nil {
+		return nil, fmt.Errorf("failed to set hello deadline: %w", err)
+	}
+
+	dialed, err := framing.Dial(conn, maxResponseSize)
 	if err != nil {
-		log.Panicf("failed to send solution: %v", err)
+		return nil, fmt.Errorf("failed to negotiate framing: %w", err)
 	}
+
+	codec, err := hello(dialed)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return nil, fmt.Errorf("failed to clear hello deadline: %w", err)
+	}
+
+	return codec, nil
 }
 
 func connectToServer() (net.Conn, error) {
-	conn, err := net.Dial("tcp", "server:8080")
+	if *useTLS {
+		return dialTLS()
+	}
+
+	conn, err := net.Dial("tcp", serverAddr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to dial server: %w", err)
 	}
@@ -61,10 +185,42 @@ func connectToServer() (net.Conn, error) {
 	return conn, nil
 }
 
-func getRequestBytes(cmd api.Command, data string) ([]byte, error) {
+// dialTLS connects with TLS, presenting the client certificate if one is given.
+func dialTLS() (net.Conn, error) {
+	tlsCfg := &tls.Config{MinVersion: tls.VersionTLS12, ServerName: *tlsServerName}
+
+	if *tlsCA != "" {
+		pool, err := tlsconfig.LoadCertPool(*tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load CA: %w", err)
+		}
+
+		tlsCfg.RootCAs = pool
+	}
+
+	if *tlsCert != "" || *tlsKey != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	conn, err := tls.Dial("tcp", serverAddr, tlsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial server with TLS: %w", err)
+	}
+
+	return conn, nil
+}
+
+func getRequestBytes(cmd api.Command, data string, challengeID string, filter *api.QuoteFilter) ([]byte, error) {
 	reqBytes, err := proto.Marshal(&api.Request{
-		Cmd:  cmd,
-		Data: data,
+		Cmd:         cmd,
+		Data:        data,
+		ChallengeId: challengeID,
+		QuoteFilter: filter,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
@@ -73,62 +229,158 @@ func getRequestBytes(cmd api.Command, data string) ([]byte, error) {
 	return reqBytes, nil
 }
 
-func getChallenge(reader *bufio.Reader, conn io.Writer) (string, error) {
-	reqBytes, err := getRequestBytes(api.Command_GET_CHALLENGE, "")
+// hello negotiates the protocol with the server and returns the codec for the rest of the session.
+// Servers without HELLO support answer with a failure and are talked to with the defaults, no
+// readable answer at all is an errNoHello error.
+func hello(codec framing.Codec) (framing.Codec, error) {
+	reqBytes, err := proto.Marshal(&api.Request{
+		Cmd: api.Command_HELLO,
+		Hello: &api.Hello{
+			ProtocolVersion: protocolVersion,
+			PowAlgorithms:   []string{pow.AlgorithmScrypt, pow.AlgorithmSHA256},
+			Framings:        []string{framing.LengthPrefixed},
+			Compressions:    []string{framing.Identity, framing.Gzip},
+		},
+	})
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to marshal hello: %w", err)
 	}
 
-	_, _ = conn.Write(append(reqBytes, '\n'))
+	if err = codec.WriteFrame(reqBytes); err != nil {
+		return nil, fmt.Errorf("failed to send hello: %w", err)
+	}
 
-	challengeResponse, err := reader.ReadBytes('\n')
+	respBytes, err := codec.ReadFrame()
 	if err != nil {
-		return "", fmt.Errorf("failed to read challenge: %w", err)
+		return nil, fmt.Errorf("%w: failed to read hello: %w", errNoHello, err)
+	}
+
+	var resp api.Response
+	if err = proto.Unmarshal(respBytes, &resp); err != nil {
+		return nil, fmt.Errorf("%w: failed to unmarshal hello: %w", errNoHello, err)
 	}
 
-	challengeResponse = challengeResponse[:len(challengeResponse)-1] // remove newline
+	if resp.GetStatus() != api.Response_SUCCESS {
+		if resp.GetCode() == api.Response_BAD_REQUEST {
+			return codec, nil
+		}
+
+		return nil, fmt.Errorf("server rejected hello: %s (%s)", resp.GetError(), resp.GetCode())
+	}
 
-	return parseResponse(challengeResponse)
+	chosen := resp.GetHello()
+	if len(chosen.GetFramings()) != 1 || len(chosen.GetCompressions()) != 1 {
+		return nil, fmt.Errorf("unexpected hello response: %v", chosen)
+	}
+
+	return framing.Switch(codec, chosen.GetFramings()[0], chosen.GetCompressions()[0])
 }
 
-func sendSolution(reader *bufio.Reader, conn io.Writer, solution string) error {
-	reqBytes, err := getRequestBytes(api.Command_CHECK_SOLUTION, solution)
+// getChallenge requests a challenge and returns it with its id.
+func getChallenge(codec framing.Codec) (string, string, error) {
+	reqBytes, err := getRequestBytes(api.Command_GET_CHALLENGE, "", "", nil)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err = codec.WriteFrame(reqBytes); err != nil {
+		return "", "", fmt.Errorf("failed to request challenge: %w", err)
+	}
+
+	challengeResponse, err := codec.ReadFrame()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read challenge: %w", err)
+	}
+
+	resp, err := parseResponse(challengeResponse)
 	if err != nil {
-		return err
+		return "", "", err
+	}
+
+	return resp.GetData(), resp.GetChallengeId(), nil
+}
+
+func sendSolution(codec framing.Codec, solution string, challengeID string, filter *api.QuoteFilter) (*api.Response, error) {
+	reqBytes, err := getRequestBytes(api.Command_CHECK_SOLUTION, solution, challengeID, filter)
+	if err != nil {
+		return nil, err
 	}
 
 	if len(reqBytes) > maxRequestSize {
-		return fmt.Errorf("the request is too large: %d bytes", len(reqBytes))
+		return nil, fmt.Errorf("the request is too large: %d bytes", len(reqBytes))
 	}
 
-	_, _ = conn.Write(append(reqBytes, '\n'))
+	if err = codec.WriteFrame(reqBytes); err != nil {
+		return nil, fmt.Errorf("failed to send solution: %w", err)
+	}
 
-	quoteResponse, err := reader.ReadBytes('\n')
+	quoteResponse, err := codec.ReadFrame()
 	if err != nil {
-		return fmt.Errorf("failed to read server message: %w", err)
+		return nil, fmt.Errorf("failed to read server message: %w", err)
 	}
 
-	quoteResponse = quoteResponse[:len(quoteResponse)-1] // remove newline
+	return parseResponse(quoteResponse)
+}
 
-	quote, err := parseResponse(quoteResponse)
+// getQuote gets a quote paid for with the credit, errCreditInvalid if the credit is no longer accepted.
+func getQuote(codec framing.Codec, credit string, filter *api.QuoteFilter) (*api.Response, error) {
+	reqBytes, err := proto.Marshal(&api.Request{
+		Cmd:         api.Command_GET_QUOTE,
+		QuoteFilter: filter,
+		Credit:      credit,
+	})
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	fmt.Printf("Zen Quote: %s\n", quote)
+	if err = codec.WriteFrame(reqBytes); err != nil {
+		return nil, fmt.Errorf("failed to request quote: %w", err)
+	}
 
-	return nil
+	quoteResponse, err := codec.ReadFrame()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read server message: %w", err)
+	}
+
+	resp, err := parseResponse(quoteResponse)
+	if err != nil && resp.GetCode() == api.Response_CREDIT_INVALID {
+		return nil, fmt.Errorf("%w: %w", errCreditInvalid, err)
+	}
+
+	return resp, err
+}
+
+// printQuote prints the quote with its attribution, servers without Quote support only send the text.
+func printQuote(resp *api.Response) {
+	quote := resp.GetQuote()
+	if quote == nil {
+		fmt.Printf("Zen Quote: %s\n", resp.GetData())
+
+		return
+	}
+
+	author := quote.GetAuthor()
+	if author == "" {
+		author = "Unknown"
+	}
+
+	fmt.Printf("Zen Quote: %s\n  - %s\n", quote.GetText(), author)
+
+	if quote.GetSource() != "" {
+		fmt.Printf("Source: %s\n", quote.GetSource())
+	}
 }
 
-func parseResponse(responseBytes []byte) (string, error) {
+// parseResponse returns the response, with an error if it is a failure.
+func parseResponse(responseBytes []byte) (*api.Response, error) {
 	var resp api.Response
 	if err := proto.Unmarshal(responseBytes, &resp); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if resp.GetStatus() != api.Response_SUCCESS {
-		return "", fmt.Errorf("server returned failure status: %s", resp.GetError())
+		return &resp, fmt.Errorf("server returned failure status: %s (%s)", resp.GetError(), resp.GetCode())
 	}
 
-	return resp.GetData(), nil
+	return &resp, nil
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"zenquote/internal/quoteadmin"
+	"zenquote/internal/quotestore"
+)
+
+const usage = `Usage: quoteadmin -store FILE COMMAND [ARGS]
+
+Maintains the JSON quote file served by the local quote provider (quotes.store.file).
+
+Commands:
+  import [-format csv|json] FILE...  normalize and add the quotes not in the store yet
+  dedupe                             normalize the store and drop duplicate quotes
+  disable ID...                      stop serving the quotes
+  enable ID...                       serve the disabled quotes again
+`
+
+const storeFileMode = 0o644 // readable by the server
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			_, _ = fmt.Fprint(os.Stderr, usage)
+		}
+
+		_, _ = fmt.Fprintf(os.Stderr, "quoteadmin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	flags := flag.NewFlagSet("quoteadmin", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	store := flags.String("store", "", "JSON quote file, created if missing")
+
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	if *store == "" || flags.NArg() == 0 {
+		return fmt.Errorf("%w: a store file and a command are required", errUsage)
+	}
+
+	collection, err := load(*store)
+	if err != nil {
+		return err
+	}
+
+	command, cmdArgs := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "import":
+		collection, err = importFiles(collection, cmdArgs)
+	case "dedupe":
+		var dropped int
+		if collection, dropped, err = quoteadmin.Dedupe(collection); err == nil {
+			fmt.Printf("dropped %d duplicate quotes, %d left\n", dropped, len(collection))
+		}
+	case "disable", "enable":
+		if len(cmdArgs) == 0 {
+			return fmt.Errorf("%w: %s needs quote ids", errUsage, command)
+		}
+
+		if err = quoteadmin.SetDisabled(collection, cmdArgs, command == "disable"); err == nil {
+			fmt.Printf("%sd %d quotes\n", command, len(cmdArgs))
+		}
+	default:
+		return fmt.Errorf("%w: unknown command %q", errUsage, command)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return save(*store, collection)
+}
+
+func importFiles(collection []quotestore.Record, args []string) ([]quotestore.Record, error) {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	format := flags.String("format", "", "format of the files, csv or json, by their extension if empty")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, fmt.Errorf("%w: %w", errUsage, err)
+	}
+
+	if flags.NArg() == 0 {
+		return nil, fmt.Errorf("%w: import needs files", errUsage)
+	}
+
+	for _, file := range flags.Args() {
+		fileFormat := *format
+		if fileFormat == "" {
+			fileFormat = strings.TrimPrefix(strings.ToLower(filepath.Ext(file)), ".")
+		}
+
+		incoming, err := readFile(file, fileFormat)
+		if err != nil {
+			return nil, err
+		}
+
+		var report quoteadmin.Report
+
+		collection, report = quoteadmin.Import(collection, incoming)
+		fmt.Printf("%s: added %d, duplicates %d, invalid %d\n", file, report.Added, report.Duplicates, report.Invalid)
+	}
+
+	return collection, nil
+}
+
+func readFile(file string, format string) ([]quotestore.Record, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("open %s failed: %w", file, err)
+	}
+
+	defer func() {
+		_ = f.Close()
+	}()
+
+	records, err := quoteadmin.Read(f, format)
+	if err != nil {
+		return nil, fmt.Errorf("read %s failed: %w", file, err)
+	}
+
+	return records, nil
+}
+
+// load reads the store, a missing file is an empty store.
+func load(file string) ([]quotestore.Record, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("read store failed: %w", err)
+	}
+
+	return quotestore.ReadRecords(data)
+}
+
+// save replaces the store with a rename, so the server never reads a partly written file.
+func save(file string, collection []quotestore.Record) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return fmt.Errorf("create temp file failed: %w", err)
+	}
+
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+
+	if err = tmp.Chmod(storeFileMode); err != nil {
+		_ = tmp.Close()
+
+		return fmt.Errorf("chmod temp file failed: %w", err)
+	}
+
+	if err = quotestore.WriteRecords(tmp, collection); err != nil {
+		_ = tmp.Close()
+
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("close temp file failed: %w", err)
+	}
+
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("replace store failed: %w", err)
+	}
+
+	return nil
+}
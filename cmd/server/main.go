@@ -2,16 +2,27 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net/http"
 	"os"
 	"time"
 	"zenquote/internal/quoteapi"
+	"zenquote/internal/quotechain"
+	"zenquote/internal/quotepool"
+	"zenquote/internal/quotestore"
 
 	"zenquote/internal/config"
+	"zenquote/internal/credit"
+	"zenquote/internal/difficulty"
 	"zenquote/internal/logger"
+	"zenquote/internal/pow"
+	"zenquote/internal/proxyproto"
+	"zenquote/internal/ratelimit"
 	storage "zenquote/internal/redisdb"
+	"zenquote/internal/replay"
+	"zenquote/internal/reputation"
 	"zenquote/internal/server/tcp"
+	"zenquote/internal/tlsconfig"
 
 	"go.uber.org/fx"
 	"go.uber.org/fx/fxevent"
@@ -21,17 +32,41 @@ import (
 var options = []fx.Option{
 	fx.Provide(
 		config.New,
+		pow.NewChallengers,
+		pow.NewVerifier,
+		pow.NewSigner,
+		newSpentRepo,
+		difficulty.NewController,
+		reputation.NewTracker,
+		ratelimit.NewLimits,
+		tlsconfig.NewReloader,
+		proxyproto.NewPolicy,
 		tcp.NewServer,
 		tcp.NewHandler,
 		logger.New,
-		func() *http.Client {
-			return http.DefaultClient
+		quoteapi.NewHTTPClient,
+		storage.NewRedisStorage,
+		func(redisStorage *storage.RedisStorage) tcp.HashcashRepo {
+			return redisStorage
 		},
-		func(cfg config.Config) tcp.HashcashRepo {
-			return storage.NewRedisStorage(cfg)
+		func(redisStorage *storage.RedisStorage) tcp.OutstandingRepo {
+			return redisStorage
 		},
-		func(client *http.Client) tcp.ZenquoteRepo {
-			return quoteapi.NewQuoteAPI(client)
+		func(redisStorage *storage.RedisStorage) reputation.Repo {
+			return redisStorage
+		},
+		func(redisStorage *storage.RedisStorage) credit.Repo {
+			return redisStorage
+		},
+		credit.NewLedger,
+		quoteapi.NewQuoteAPI,
+		func(api *quoteapi.QuoteAPI) quotepool.Fetcher {
+			return api
+		},
+		newQuotePool,
+		newQuoteChain,
+		func(chain *quotechain.Chain) tcp.ZenquoteRepo {
+			return chain
 		},
 	),
 	fx.Invoke(func(
@@ -40,15 +75,30 @@ var options = []fx.Option{
 		config config.Config,
 		logger *zap.Logger,
 		server *tcp.Server,
+		difficulty *difficulty.Controller,
+		pool *quotepool.Pool,
+		chain *quotechain.Chain,
 	) {
 		lc.Append(fx.Hook{
 			OnStart: func(startCtx context.Context) error {
+				go difficulty.Start()
+				go chain.Start()
+
+				if pool != nil {
+					go pool.Start()
+				}
+
 				go server.Start(startCtx, stop)
 
 				return nil
 			},
 			OnStop: func(stopCtx context.Context) error {
-				go server.Shutdown()
+				server.Shutdown(stopCtx)
+				difficulty.Stop()
+				chain.Stop()
+				if pool != nil {
+					pool.Stop()
+				}
 				_ = logger.Sync()
 
 				return nil
@@ -62,6 +112,106 @@ var options = []fx.Option{
 	}),
 }
 
+var (
+	errUnknownReplayStore   = errors.New("unknown replay store")
+	errUnknownQuoteProvider = errors.New("unknown quote provider")
+)
+
+func newSpentRepo(cfg config.Config, redisStorage *storage.RedisStorage) (tcp.SpentRepo, error) {
+	switch cfg.Pow.Replay.Store {
+	case "redis", "":
+		return redisStorage, nil
+	case "memory":
+		return replay.NewCache(cfg), nil
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnknownReplayStore, cfg.Pow.Replay.Store)
+	}
+}
+
+// newQuotePool returns the pool of prefetched quotes, nil if the pool provider is not configured.
+func newQuotePool(cfg config.Config, logger *zap.Logger, fetcher quotepool.Fetcher) (*quotepool.Pool, error) {
+	if !usesQuoteProvider(cfg, "pool") {
+		return nil, nil
+	}
+
+	pool, err := quotepool.NewPool(cfg, logger, fetcher)
+	if err != nil {
+		return nil, fmt.Errorf("new quote pool failed: %w", err)
+	}
+
+	return pool, nil
+}
+
+// newQuoteChain chains the configured quote providers. Quotes served by the api or the pool
+// are kept in the Redis cache when the redis provider is configured.
+func newQuoteChain(
+	cfg config.Config,
+	logger *zap.Logger,
+	api *quoteapi.QuoteAPI,
+	pool *quotepool.Pool,
+	redisStorage *storage.RedisStorage,
+) (*quotechain.Chain, error) {
+	var cache *quotechain.Cache
+
+	if usesQuoteProvider(cfg, "redis") {
+		var err error
+		if cache, err = quotechain.NewCache(cfg, redisStorage); err != nil {
+			return nil, fmt.Errorf("new quote cache failed: %w", err)
+		}
+	}
+
+	cached := func(repo quotechain.Repo) quotechain.Repo {
+		if cache == nil {
+			return repo
+		}
+
+		return quotechain.CacheThrough(repo, cache, logger)
+	}
+
+	providers := make([]quotechain.Provider, 0, len(cfg.Quotes.Providers))
+
+	for _, p := range cfg.Quotes.Providers {
+		var repo quotechain.Repo
+
+		switch p.Name {
+		case "api":
+			repo = cached(api)
+		case "pool":
+			repo = cached(pool)
+		case "redis":
+			repo = cache
+		case "local":
+			store, err := quotestore.NewStore(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("new quote store failed: %w", err)
+			}
+
+			repo = store
+		default:
+			return nil, fmt.Errorf("%w: %s", errUnknownQuoteProvider, p.Name)
+		}
+
+		providers = append(providers, quotechain.Provider{Name: p.Name, Repo: repo, Timeout: p.Timeout})
+	}
+
+	chain, err := quotechain.NewChain(cfg, logger, providers...)
+	if err != nil {
+		return nil, fmt.Errorf("new quote chain failed: %w", err)
+	}
+
+	return chain, nil
+}
+
+func usesQuoteProvider(cfg config.Config, name string) bool {
+	for _, p := range cfg.Quotes.Providers {
+		if p.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 func main() {
 	app := fx.New(options...)
 
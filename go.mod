@@ -9,6 +9,8 @@ require (
 	go.uber.org/config v1.4.0
 	go.uber.org/fx v1.20.0
 	go.uber.org/zap v1.24.0
+	golang.org/x/crypto v0.0.0-20220525230936-793ad666bf5e
+	golang.org/x/text v0.3.8
 	google.golang.org/protobuf v1.31.0
 )
 
@@ -24,7 +26,6 @@ require (
 	go.uber.org/multierr v1.6.0 // indirect
 	golang.org/x/lint v0.0.0-20190930215403-16217165b5de // indirect
 	golang.org/x/sys v0.10.0 // indirect
-	golang.org/x/text v0.3.8 // indirect
 	golang.org/x/tools v0.11.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
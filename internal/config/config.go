@@ -22,9 +22,72 @@ type Logger struct {
 type TCP struct {
 	Host             string        `yaml:"host"`
 	Port             uint16        `yaml:"port"`
-	ReqTimeout       time.Duration `yaml:"reqTimeout"`
+	ReqTimeout       time.Duration `yaml:"reqTimeout"` // handling of one request
 	MaxReqSizeBytes  int           `yaml:"maxReqSizeBytes"`
 	MaxReqPerSession int           `yaml:"maxReqPerSession"`
+	RateLimit        RateLimit     `yaml:"rateLimit"`
+	Concurrency      Concurrency   `yaml:"concurrency"`
+	TLS              TLS           `yaml:"tls"`
+	ProxyProtocol    ProxyProtocol `yaml:"proxyProtocol"`
+	Timeouts         Timeouts      `yaml:"timeouts"`
+}
+
+// Timeouts bound each phase of a session, zero does not limit it.
+type Timeouts struct {
+	Idle       time.Duration `yaml:"idle"`       // wait for the next request
+	Write      time.Duration `yaml:"write"`      // write one response
+	Solve      time.Duration `yaml:"solve"`      // extra wait for the solution of a challenge of solveBits
+	SolveBits  int           `yaml:"solveBits"`  // the solve time doubles with each bit over, halves with each under
+	MaxSession time.Duration `yaml:"maxSession"` // whole session, however active
+}
+
+// ProxyProtocol takes the client address from the PROXY protocol header, v1 or v2, sent by the load
+// balancers in front of the server. Only connections from the trusted proxies are expected to send it.
+type ProxyProtocol struct {
+	Enabled      bool     `yaml:"enabled"`
+	TrustedCIDRs []string `yaml:"trustedCIDRs"`
+}
+
+// TLS encrypts the TCP protocol. With a client CA, clients must present a certificate signed by it.
+type TLS struct {
+	Enabled        bool          `yaml:"enabled"`
+	CertFile       string        `yaml:"certFile"`
+	KeyFile        string        `yaml:"keyFile"`
+	MinVersion     string        `yaml:"minVersion"`     // 1.2 or 1.3
+	ClientCAFile   string        `yaml:"clientCAFile"`   // enables mutual TLS
+	ReloadInterval time.Duration `yaml:"reloadInterval"` // how often the files are checked for changes
+}
+
+// Concurrency bounds the connections served at once. Connections beyond the bound are shed:
+// a few are answered BUSY with a retry delay, the rest are closed right away.
+type Concurrency struct {
+	MaxConns    int           `yaml:"maxConns"`    // connections served at once, 0 for no limit
+	MaxShedding int           `yaml:"maxShedding"` // connections being answered BUSY at once
+	ShedTimeout time.Duration `yaml:"shedTimeout"` // time to answer BUSY to a connection
+	RetryAfter  time.Duration `yaml:"retryAfter"`  // retry delay sent with BUSY
+}
+
+// RateLimit configures token buckets limiting new connections and issued challenges for each client IP
+// and for each subnet of client IPs, so a host can not get around the limit with more addresses.
+type RateLimit struct {
+	Enabled     bool   `yaml:"enabled"`
+	IPv4Prefix  int    `yaml:"ipv4Prefix"` // subnet prefix length of IPv4 clients
+	IPv6Prefix  int    `yaml:"ipv6Prefix"` // subnet prefix length of IPv6 clients
+	MaxClients  int    `yaml:"maxClients"` // buckets kept per limiter, the least recently used are dropped beyond
+	Connections Budget `yaml:"connections"`
+	Challenges  Budget `yaml:"challenges"`
+}
+
+// Budget is the bucket of each client IP and of each subnet, both must have a token left.
+type Budget struct {
+	IP     Bucket `yaml:"ip"`
+	Subnet Bucket `yaml:"subnet"`
+}
+
+// Bucket holds up to Burst tokens and gains Rate tokens per second.
+type Bucket struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
 }
 
 type Redis struct {
@@ -32,10 +95,156 @@ type Redis struct {
 	Port uint16 `yaml:"port"`
 }
 
+type Pow struct {
+	Algorithm      string        `yaml:"algorithm"`      // sha256 or scrypt
+	Algorithms     []string      `yaml:"algorithms"`     // extra algorithms clients may select with HELLO
+	Bits           int           `yaml:"bits"`           // leading zero bits required in a solution hash
+	MaxAge         time.Duration `yaml:"maxAge"`         // how long an issued challenge can be solved
+	ClockSkew      time.Duration `yaml:"clockSkew"`      // allowed clock difference between server instances
+	MaxOutstanding int           `yaml:"maxOutstanding"` // unsolved stored challenges per client IP, 0 for no limit
+	Difficulty     Difficulty    `yaml:"difficulty"`
+	Scrypt         Scrypt        `yaml:"scrypt"`
+	Stateless      Stateless     `yaml:"stateless"`
+	Replay         Replay        `yaml:"replay"`
+	Credits        Credits       `yaml:"credits"`
+}
+
+// Credits configures the tokens minted for solved challenges. A token pays for Quotes more quotes
+// within TTL without solving another challenge, 0 Quotes makes it unlimited until it expires.
+// Tokens are signed with Secret and their balance is kept in Redis.
+type Credits struct {
+	Enabled bool          `yaml:"enabled"`
+	Quotes  int           `yaml:"quotes"`
+	TTL     time.Duration `yaml:"ttl"`
+	Secret  string        `yaml:"secret"`
+}
+
+// Stateless configures HMAC signed challenges verified without the challenge repository.
+// Keys maps a key id to its secret, ActiveKey signs new challenges and the rest are kept
+// for verification while rotating.
+type Stateless struct {
+	Enabled   bool              `yaml:"enabled"`
+	ActiveKey string            `yaml:"activeKey"`
+	Keys      map[string]string `yaml:"keys"`
+}
+
+// Replay configures where solved challenges are remembered: redis, shared by all server
+// instances, or memory, a local cache of at most CacheSize challenges.
+type Replay struct {
+	Store     string `yaml:"store"`
+	CacheSize int    `yaml:"cacheSize"`
+}
+
+// Scrypt configures the memory-hard puzzle, every attempt needs about 128*N*R bytes of memory.
+type Scrypt struct {
+	N            int `yaml:"n"`
+	R            int `yaml:"r"`
+	P            int `yaml:"p"`
+	BitsDiscount int `yaml:"bitsDiscount"` // bits taken off the difficulty of a sha256 challenge
+}
+
+// Difficulty configures adaptive challenge difficulty. When Adaptive is false Pow.Bits is used.
+// Each High* value is the load treated as full pressure and raising difficulty up to MaxBits;
+// zero disables that signal.
+type Difficulty struct {
+	Adaptive        bool          `yaml:"adaptive"`
+	MinBits         int           `yaml:"minBits"`
+	MaxBits         int           `yaml:"maxBits"`
+	Smoothing       float64       `yaml:"smoothing"` // weight of the newest load sample in (0, 1]
+	SampleInterval  time.Duration `yaml:"sampleInterval"`
+	HighConnRate    float64       `yaml:"highConnRate"` // accepted connections per second
+	HighInFlight    int           `yaml:"highInFlight"`
+	HighRepoLatency time.Duration `yaml:"highRepoLatency"`
+}
+
+// Reputation configures per client difficulty. Bad behaviour within Window scores points
+// and every PointsPerBit points add a bit, up to MaxPenaltyBits. Requests above FreeRequests
+// per Window score RequestPoints each. Clients with a clean history and at least TrustedSolved
+// solutions get BonusBits fewer bits.
+type Reputation struct {
+	Enabled         bool          `yaml:"enabled"`
+	Window          time.Duration `yaml:"window"`
+	FailedPoints    int64         `yaml:"failedPoints"`
+	MalformedPoints int64         `yaml:"malformedPoints"`
+	LimitPoints     int64         `yaml:"limitPoints"`
+	FreeRequests    int64         `yaml:"freeRequests"`
+	RequestPoints   int64         `yaml:"requestPoints"`
+	PointsPerBit    int64         `yaml:"pointsPerBit"`
+	MaxPenaltyBits  int           `yaml:"maxPenaltyBits"`
+	TrustedSolved   int64         `yaml:"trustedSolved"`
+	BonusBits       int           `yaml:"bonusBits"`
+}
+
+// Quotes configures where the quotes for solved challenges come from.
+// Providers are tried in order until one of them serves a quote.
+type Quotes struct {
+	Provider      string          `yaml:"provider"` // deprecated single provider, used when Providers is empty
+	Providers     []QuoteProvider `yaml:"providers"`
+	StatsInterval time.Duration   `yaml:"statsInterval"` // how often the provider counters are logged, 0 never
+	Breaker       Breaker         `yaml:"breaker"`
+	Cache         QuoteCache      `yaml:"cache"`
+	Pool          QuotePool       `yaml:"pool"`
+	Store         QuoteStore      `yaml:"store"`
+	API           QuoteAPI        `yaml:"api"`
+}
+
+type QuoteProvider struct {
+	Name    string        `yaml:"name"`    // api, local or redis
+	Timeout time.Duration `yaml:"timeout"` // limit of a single attempt, 0 for no limit
+}
+
+// Breaker configures the circuit breaker of each quote provider: after Failures consecutive failures
+// the provider is skipped for Cooldown.
+type Breaker struct {
+	Failures int           `yaml:"failures"`
+	Cooldown time.Duration `yaml:"cooldown"`
+}
+
+// QuoteCache configures the Redis cache of the quotes served by the api provider.
+type QuoteCache struct {
+	Size int64 `yaml:"size"` // quotes kept, random ones are evicted beyond
+}
+
+// QuotePool configures the pool of quotes prefetched from the api in batches.
+type QuotePool struct {
+	Size         int           `yaml:"size"`         // quotes kept at most
+	LowWatermark int           `yaml:"lowWatermark"` // the pool is refilled below this many quotes
+	MinInterval  time.Duration `yaml:"minInterval"`  // least time between two fetches
+	MaxBackoff   time.Duration `yaml:"maxBackoff"`   // longest wait after failed fetches
+	FetchTimeout time.Duration `yaml:"fetchTimeout"`
+}
+
+// QuoteAPI configures the client of a ZenQuotes compatible API. Empty URL and paths default to ZenQuotes.
+type QuoteAPI struct {
+	BaseURL    string            `yaml:"baseURL"`
+	RandomPath string            `yaml:"randomPath"`
+	QuotesPath string            `yaml:"quotesPath"` // bulk endpoint used by the pool
+	APIKey     string            `yaml:"apiKey"`     // appended to the path as a last segment, as ZenQuotes expects
+	Headers    map[string]string `yaml:"headers"`    // added to every request
+	Timeout    time.Duration     `yaml:"timeout"`    // limit of a request including the body, 0 for no limit
+	Proxy      string            `yaml:"proxy"`      // proxy URL, the HTTP_PROXY environment variables if empty
+	CAFile     string            `yaml:"caFile"`     // PEM certificates trusted besides the system ones
+	Retry      Retry             `yaml:"retry"`
+}
+
+// Retry configures retries of requests failing on the network or with a server error.
+type Retry struct {
+	Attempts int           `yaml:"attempts"` // including the first one
+	Backoff  time.Duration `yaml:"backoff"`  // wait before the first retry, doubled before each next one
+}
+
+// QuoteStore configures the local quote store.
+type QuoteStore struct {
+	File string `yaml:"file"` // JSON file with quotes, the bundled dataset if empty
+}
+
 type Config struct {
-	TCP    TCP    `yaml:"tcp"`
-	Redis  Redis  `yaml:"redis"`
-	Logger Logger `yaml:"logger"`
+	TCP        TCP        `yaml:"tcp"`
+	Redis      Redis      `yaml:"redis"`
+	Pow        Pow        `yaml:"pow"`
+	Reputation Reputation `yaml:"reputation"`
+	Quotes     Quotes     `yaml:"quotes"`
+	Logger     Logger     `yaml:"logger"`
 }
 
 func New() (Config, error) {
@@ -51,5 +260,10 @@ func New() (Config, error) {
 		return Config{}, fmt.Errorf("unmarshal yaml failed: %w", err)
 	}
 
+	// configs written before the provider chain name a single provider
+	if len(config.Quotes.Providers) == 0 && config.Quotes.Provider != "" {
+		config.Quotes.Providers = []QuoteProvider{{Name: config.Quotes.Provider, Timeout: 0}}
+	}
+
 	return config, nil
 }
@@ -0,0 +1,175 @@
+package credit
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"zenquote/internal/config"
+)
+
+const (
+	keyPrefix = "credit:" // key prefix of the credit balances by token id
+	tokenSep  = "."
+	idBytes   = 16
+	unlimited = -1 // balance of credits limited by their expiry only
+)
+
+var (
+	ErrDisabled      = errors.New("credits disabled")
+	ErrInvalidConfig = errors.New("invalid credits config")
+	ErrInvalidToken  = errors.New("invalid credit token")
+	ErrExpired       = errors.New("credit expired")
+	ErrExhausted     = errors.New("credit used up")
+)
+
+// Repo keeps the balance of each credit.
+type Repo interface {
+	Store(ctx context.Context, key string, value string, ttl time.Duration) error
+	DecrCounter(ctx context.Context, key string) (int64, bool, error)
+	IncrCounter(ctx context.Context, key string) error
+}
+
+// Credit is a token paying for quotes without solving a challenge.
+type Credit struct {
+	Token     string
+	Remaining int64 // quotes left, -1 if unlimited until ExpiresAt
+	ExpiresAt time.Time
+}
+
+// Ledger mints a credit for each solved challenge and spends them on quotes. Tokens are
+// "id.expiry.mac" signed with HMAC-SHA256, so forged and expired ones are rejected without
+// a repo lookup, and the balance is kept in the repo under the token id.
+type Ledger struct {
+	cfg  config.Credits
+	repo Repo
+	now  func() time.Time
+}
+
+func NewLedger(cfg config.Config, repo Repo) (*Ledger, error) {
+	ccfg := cfg.Pow.Credits
+
+	if ccfg.Enabled && (ccfg.Secret == "" || ccfg.TTL < time.Second || ccfg.Quotes < 0) {
+		return nil, fmt.Errorf("%w: a secret, a ttl of at least 1s and a quotes count are required", ErrInvalidConfig)
+	}
+
+	return &Ledger{cfg: ccfg, repo: repo, now: time.Now}, nil
+}
+
+// Enabled reports whether solved challenges mint credits.
+func (l *Ledger) Enabled() bool {
+	return l.cfg.Enabled
+}
+
+// Mint issues a new credit worth the configured number of quotes.
+func (l *Ledger) Mint(ctx context.Context) (Credit, error) {
+	if !l.cfg.Enabled {
+		return Credit{}, ErrDisabled
+	}
+
+	buf := make([]byte, idBytes)
+	if _, err := rand.Read(buf); err != nil {
+		return Credit{}, fmt.Errorf("generate credit id failed: %w", err)
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(buf)
+	expiresAt := time.Unix(l.now().Add(l.cfg.TTL).Unix(), 0)
+
+	remaining := int64(l.cfg.Quotes)
+	if remaining == 0 {
+		remaining = unlimited
+	}
+
+	if err := l.repo.Store(ctx, keyPrefix+id, strconv.FormatInt(remaining, 10), l.cfg.TTL); err != nil {
+		return Credit{}, fmt.Errorf("store credit failed: %w", err)
+	}
+
+	return Credit{Token: l.token(id, expiresAt), Remaining: remaining, ExpiresAt: expiresAt}, nil
+}
+
+// Spend takes a quote from the credit of the token and returns what is left of it.
+func (l *Ledger) Spend(ctx context.Context, token string) (Credit, error) {
+	if !l.cfg.Enabled {
+		return Credit{}, ErrDisabled
+	}
+
+	id, expiresAt, err := l.parse(token)
+	if err != nil {
+		return Credit{}, err
+	}
+
+	if !l.now().Before(expiresAt) {
+		return Credit{}, ErrExpired
+	}
+
+	remaining, ok, err := l.repo.DecrCounter(ctx, keyPrefix+id)
+	if err != nil {
+		return Credit{}, fmt.Errorf("spend credit failed: %w", err)
+	}
+
+	if !ok {
+		return Credit{}, ErrExhausted
+	}
+
+	return Credit{Token: token, Remaining: remaining, ExpiresAt: expiresAt}, nil
+}
+
+// Refund gives back the quote taken by Spend when no quote could be served for it.
+// The balance of an unlimited or expired credit is left as it is.
+func (l *Ledger) Refund(ctx context.Context, spent Credit) (Credit, error) {
+	if spent.Remaining < 0 {
+		return spent, nil
+	}
+
+	id, _, err := l.parse(spent.Token)
+	if err != nil {
+		return spent, err
+	}
+
+	if err = l.repo.IncrCounter(ctx, keyPrefix+id); err != nil {
+		return spent, fmt.Errorf("refund credit failed: %w", err)
+	}
+
+	spent.Remaining++
+
+	return spent, nil
+}
+
+func (l *Ledger) token(id string, expiresAt time.Time) string {
+	payload := id + tokenSep + strconv.FormatInt(expiresAt.Unix(), 10)
+
+	return payload + tokenSep + l.mac(payload)
+}
+
+// parse authenticates the token and returns its id and expiry.
+func (l *Ledger) parse(token string) (string, time.Time, error) {
+	parts := strings.Split(token, tokenSep)
+	if len(parts) != 3 {
+		return "", time.Time{}, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	payload := parts[0] + tokenSep + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(l.mac(payload))) {
+		return "", time.Time{}, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("%w: bad expiry", ErrInvalidToken)
+	}
+
+	return parts[0], time.Unix(expiresAt, 0), nil
+}
+
+func (l *Ledger) mac(payload string) string {
+	h := hmac.New(sha256.New, []byte(l.cfg.Secret))
+	h.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,209 @@
+package credit
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MockRepo keeps the counters in memory, without expiry.
+type MockRepo struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func NewMockRepo() *MockRepo {
+	return &MockRepo{mu: sync.Mutex{}, counters: make(map[string]int64)}
+}
+
+func (mr *MockRepo) Store(_ context.Context, key string, value string, _ time.Duration) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+
+	mr.counters[key] = n
+
+	return nil
+}
+
+func (mr *MockRepo) DecrCounter(_ context.Context, key string) (int64, bool, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	n, ok := mr.counters[key]
+
+	switch {
+	case !ok || n == 0:
+		return 0, false, nil
+	case n < 0:
+		return n, true, nil
+	default:
+		mr.counters[key] = n - 1
+
+		return n - 1, true, nil
+	}
+}
+
+func (mr *MockRepo) IncrCounter(_ context.Context, key string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if n, ok := mr.counters[key]; ok && n >= 0 {
+		mr.counters[key] = n + 1
+	}
+
+	return nil
+}
+
+func testConfig(quotes int) config.Config {
+	cfg := config.Config{}
+	cfg.Pow.Credits = config.Credits{Enabled: true, Quotes: quotes, TTL: time.Minute, Secret: "secret"}
+
+	return cfg
+}
+
+func TestSpend(t *testing.T) {
+	t.Parallel()
+
+	ledger, err := NewLedger(testConfig(2), NewMockRepo())
+	require.NoError(t, err)
+
+	credit, err := ledger.Mint(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), credit.Remaining)
+
+	for _, expected := range []int64{1, 0} {
+		spent, err := ledger.Spend(context.Background(), credit.Token)
+		require.NoError(t, err)
+		assert.Equal(t, expected, spent.Remaining)
+		assert.Equal(t, credit.ExpiresAt, spent.ExpiresAt)
+	}
+
+	_, err = ledger.Spend(context.Background(), credit.Token)
+	assert.ErrorIs(t, err, ErrExhausted)
+}
+
+func TestRefund(t *testing.T) {
+	t.Parallel()
+
+	ledger, err := NewLedger(testConfig(1), NewMockRepo())
+	require.NoError(t, err)
+
+	credit, err := ledger.Mint(context.Background())
+	require.NoError(t, err)
+
+	spent, err := ledger.Spend(context.Background(), credit.Token)
+	require.NoError(t, err)
+
+	refunded, err := ledger.Refund(context.Background(), spent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), refunded.Remaining)
+
+	// the refunded quote can be spent again
+	spent, err = ledger.Spend(context.Background(), credit.Token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), spent.Remaining)
+
+	unlimited, err := NewLedger(testConfig(0), NewMockRepo())
+	require.NoError(t, err)
+
+	credit, err = unlimited.Mint(context.Background())
+	require.NoError(t, err)
+
+	refunded, err = unlimited.Refund(context.Background(), credit)
+	require.NoError(t, err)
+	assert.Equal(t, int64(-1), refunded.Remaining)
+}
+
+func TestSpendUnlimited(t *testing.T) {
+	t.Parallel()
+
+	ledger, err := NewLedger(testConfig(0), NewMockRepo())
+	require.NoError(t, err)
+
+	now := time.Now()
+	ledger.now = func() time.Time { return now }
+
+	credit, err := ledger.Mint(context.Background())
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		spent, err := ledger.Spend(context.Background(), credit.Token)
+		require.NoError(t, err)
+		assert.Equal(t, int64(-1), spent.Remaining)
+	}
+
+	// the credit is rejected once expired, even if the repo still holds it
+	now = now.Add(time.Minute)
+
+	_, err = ledger.Spend(context.Background(), credit.Token)
+	assert.ErrorIs(t, err, ErrExpired)
+}
+
+func TestSpendInvalid(t *testing.T) {
+	t.Parallel()
+
+	ledger, err := NewLedger(testConfig(2), NewMockRepo())
+	require.NoError(t, err)
+
+	credit, err := ledger.Mint(context.Background())
+	require.NoError(t, err)
+
+	parts := strings.Split(credit.Token, tokenSep)
+
+	other, err := NewLedger(testConfig(2), NewMockRepo())
+	require.NoError(t, err)
+
+	other.cfg.Secret = "other secret"
+	forged, err := other.Mint(context.Background())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty", token: ""},
+		{name: "malformed", token: "abc.def"},
+		{name: "extended expiry", token: parts[0] + tokenSep + "99999999999" + tokenSep + parts[2]},
+		{name: "other secret", token: forged.Token},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ledger.Spend(context.Background(), tcCopy.token)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func TestNewLedger(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig(2)
+	cfg.Pow.Credits.Secret = ""
+
+	_, err := NewLedger(cfg, NewMockRepo())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	cfg.Pow.Credits.Enabled = false
+
+	ledger, err := NewLedger(cfg, NewMockRepo())
+	require.NoError(t, err)
+
+	_, err = ledger.Mint(context.Background())
+	assert.ErrorIs(t, err, ErrDisabled)
+}
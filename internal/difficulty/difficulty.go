@@ -0,0 +1,157 @@
+package difficulty
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zenquote/internal/config"
+)
+
+const maxBits = 256 // size of the challenge hash, more leading zero bits can not be found
+
+var ErrInvalidConfig = errors.New("invalid difficulty config")
+
+// Controller chooses the number of bits for new challenges.
+// In adaptive mode it raises difficulty as the accepted connection rate, the number of connections
+// in flight or the repository latency climb, and relaxes it when the load drops.
+type Controller struct {
+	cfg      config.Difficulty
+	baseBits int
+
+	accepted atomic.Int64 // connections accepted since the last sample
+	inFlight atomic.Int64 // connections currently being served
+	bits     atomic.Int64 // bits for new challenges
+
+	mu           sync.Mutex
+	latencySum   time.Duration // repo latency observed since the last sample
+	latencyCount int64
+	load         float64 // smoothed load in [0, 1]
+	lastSample   time.Time
+
+	closeChan chan struct{}
+}
+
+func NewController(cfg config.Config) (*Controller, error) {
+	dcfg := cfg.Pow.Difficulty
+	if dcfg.Adaptive {
+		if dcfg.MinBits < 0 || dcfg.MaxBits < dcfg.MinBits || dcfg.MaxBits > maxBits {
+			return nil, fmt.Errorf("%w: bits range [%d, %d]", ErrInvalidConfig, dcfg.MinBits, dcfg.MaxBits)
+		}
+
+		if dcfg.Smoothing <= 0 || dcfg.Smoothing > 1 {
+			return nil, fmt.Errorf("%w: smoothing %v not in (0, 1]", ErrInvalidConfig, dcfg.Smoothing)
+		}
+
+		if dcfg.SampleInterval <= 0 {
+			return nil, fmt.Errorf("%w: sample interval %s", ErrInvalidConfig, dcfg.SampleInterval)
+		}
+	}
+
+	ctrl := &Controller{
+		cfg:          dcfg,
+		baseBits:     cfg.Pow.Bits,
+		accepted:     atomic.Int64{},
+		inFlight:     atomic.Int64{},
+		bits:         atomic.Int64{},
+		mu:           sync.Mutex{},
+		latencySum:   0,
+		latencyCount: 0,
+		load:         0,
+		lastSample:   time.Now(),
+		closeChan:    make(chan struct{}),
+	}
+
+	if dcfg.Adaptive {
+		ctrl.bits.Store(int64(dcfg.MinBits))
+	} else {
+		ctrl.bits.Store(int64(cfg.Pow.Bits))
+	}
+
+	return ctrl, nil
+}
+
+// Bits returns the number of leading zero bits required for a new challenge.
+func (c *Controller) Bits() int {
+	return int(c.bits.Load())
+}
+
+// ConnAccepted records a newly accepted connection.
+func (c *Controller) ConnAccepted() {
+	c.accepted.Add(1)
+	c.inFlight.Add(1)
+}
+
+// ConnClosed records that a connection has been served.
+func (c *Controller) ConnClosed() {
+	c.inFlight.Add(-1)
+}
+
+// ObserveRepoLatency records the duration of a challenge repository call.
+func (c *Controller) ObserveRepoLatency(latency time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.latencySum += latency
+	c.latencyCount++
+}
+
+// Start samples the load every SampleInterval until Stop is called.
+// It returns immediately when adaptive difficulty is disabled.
+func (c *Controller) Start() {
+	if !c.cfg.Adaptive {
+		return
+	}
+
+	ticker := time.NewTicker(c.cfg.SampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case now := <-ticker.C:
+			c.sample(now)
+		}
+	}
+}
+
+// Stop stops sampling. It must be called at most once.
+func (c *Controller) Stop() {
+	close(c.closeChan)
+}
+
+// sample folds the load observed since the previous sample into the smoothed load
+// and recalculates the challenge bits.
+func (c *Controller) sample(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elapsed := now.Sub(c.lastSample).Seconds()
+	c.lastSample = now
+
+	var pressure float64
+
+	if c.cfg.HighConnRate > 0 && elapsed > 0 {
+		connRate := float64(c.accepted.Swap(0)) / elapsed
+		pressure = math.Max(pressure, connRate/c.cfg.HighConnRate)
+	}
+
+	if c.cfg.HighInFlight > 0 {
+		pressure = math.Max(pressure, float64(c.inFlight.Load())/float64(c.cfg.HighInFlight))
+	}
+
+	if c.cfg.HighRepoLatency > 0 && c.latencyCount > 0 {
+		avgLatency := c.latencySum / time.Duration(c.latencyCount)
+		pressure = math.Max(pressure, float64(avgLatency)/float64(c.cfg.HighRepoLatency))
+	}
+
+	c.latencySum, c.latencyCount = 0, 0
+
+	c.load = c.cfg.Smoothing*math.Min(pressure, 1) + (1-c.cfg.Smoothing)*c.load
+
+	bitsRange := float64(c.cfg.MaxBits - c.cfg.MinBits)
+	c.bits.Store(int64(c.cfg.MinBits + int(math.Round(c.load*bitsRange))))
+}
@@ -0,0 +1,146 @@
+package difficulty
+
+import (
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func adaptiveConfig() config.Config {
+	return config.Config{
+		TCP:   config.TCP{Host: "", Port: 0, ReqTimeout: 0, MaxReqSizeBytes: 0, MaxReqPerSession: 0},
+		Redis: config.Redis{Host: "", Port: 0},
+		Pow: config.Pow{
+			Bits: 20,
+			Difficulty: config.Difficulty{
+				Adaptive:        true,
+				MinBits:         16,
+				MaxBits:         24,
+				Smoothing:       0.5,
+				SampleInterval:  time.Second,
+				HighConnRate:    100,
+				HighInFlight:    10,
+				HighRepoLatency: 100 * time.Millisecond,
+			},
+		},
+		Logger: config.Logger{Level: "", Encoding: "", Colored: false, Tags: nil},
+	}
+}
+
+func TestStaticBits(t *testing.T) {
+	t.Parallel()
+
+	cfg := adaptiveConfig()
+	cfg.Pow.Difficulty.Adaptive = false
+
+	ctrl, err := NewController(cfg)
+	require.NoError(t, err)
+
+	ctrl.ConnAccepted()
+	ctrl.sample(time.Now())
+
+	assert.Equal(t, 20, ctrl.Bits())
+}
+
+func TestInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name   string
+		modify func(cfg *config.Difficulty)
+	}{
+		{name: "inverted range", modify: func(cfg *config.Difficulty) { cfg.MaxBits = cfg.MinBits - 1 }},
+		{name: "bits over hash size", modify: func(cfg *config.Difficulty) { cfg.MaxBits = 257 }},
+		{name: "zero smoothing", modify: func(cfg *config.Difficulty) { cfg.Smoothing = 0 }},
+		{name: "zero interval", modify: func(cfg *config.Difficulty) { cfg.SampleInterval = 0 }},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := adaptiveConfig()
+			tcCopy.modify(&cfg.Pow.Difficulty)
+
+			_, err := NewController(cfg)
+			assert.ErrorIs(t, err, ErrInvalidConfig)
+		})
+	}
+}
+
+func TestAdaptiveBits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		load func(ctrl *Controller)
+	}{
+		{
+			name: "connection rate",
+			load: func(ctrl *Controller) {
+				for i := 0; i < 200; i++ {
+					ctrl.ConnAccepted()
+					ctrl.ConnClosed()
+				}
+			},
+		},
+		{
+			name: "in flight",
+			load: func(ctrl *Controller) {
+				for i := 0; i < 10; i++ {
+					ctrl.ConnAccepted()
+				}
+				ctrl.accepted.Store(0)
+			},
+		},
+		{
+			name: "repo latency",
+			load: func(ctrl *Controller) {
+				ctrl.ObserveRepoLatency(300 * time.Millisecond)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl, err := NewController(adaptiveConfig())
+			require.NoError(t, err)
+			assert.Equal(t, 16, ctrl.Bits())
+
+			now := ctrl.lastSample
+			prev := ctrl.Bits()
+
+			// difficulty climbs while the load holds
+			for i := 0; i < 10; i++ {
+				tcCopy.load(ctrl)
+				now = now.Add(time.Second)
+				ctrl.sample(now)
+
+				assert.GreaterOrEqual(t, ctrl.Bits(), prev)
+				prev = ctrl.Bits()
+			}
+
+			assert.Equal(t, 24, ctrl.Bits())
+
+			// and relaxes once the load is gone
+			ctrl.inFlight.Store(0)
+
+			for i := 0; i < 10; i++ {
+				now = now.Add(time.Second)
+				ctrl.sample(now)
+
+				assert.LessOrEqual(t, ctrl.Bits(), prev)
+				prev = ctrl.Bits()
+			}
+
+			assert.Equal(t, 16, ctrl.Bits())
+		})
+	}
+}
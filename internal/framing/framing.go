@@ -0,0 +1,253 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Version of the length-prefixed framing announced in the preamble.
+const Version = 1
+
+// Framing and compression names exchanged in the HELLO handshake.
+const (
+	Newline        = "newline"
+	LengthPrefixed = "length-prefixed"
+	Identity       = "identity"
+	Gzip           = "gzip"
+)
+
+const (
+	preambleMagic  = "\x00ZQ" // a protobuf message never starts with a zero byte
+	lineDelimiter  = '\n'
+	minReaderBytes = 16
+)
+
+var (
+	ErrFrameTooLarge      = errors.New("frame too large")
+	ErrUnsupportedVersion = errors.New("unsupported framing version")
+	ErrUnknownFraming     = errors.New("unknown framing")
+	ErrUnknownCompression = errors.New("unknown compression")
+)
+
+type Reader interface {
+	ReadFrame() ([]byte, error)
+}
+
+type Writer interface {
+	WriteFrame(frame []byte) error
+}
+
+// Codec reads and writes whole messages on a stream.
+type Codec interface {
+	Reader
+	Writer
+	// Name returns the framing of the codec.
+	Name() string
+}
+
+// streamer is implemented by codecs that can be switched to another framing.
+type streamer interface {
+	stream() (*bufio.Reader, io.Writer, int)
+}
+
+// Framings returns the supported framings.
+func Framings() []string {
+	return []string{LengthPrefixed, Newline}
+}
+
+// Compressions returns the supported compressions.
+// Compressed frames may contain any bytes, so compression requires the length-prefixed framing.
+func Compressions() []string {
+	return []string{Identity, Gzip}
+}
+
+// Switch returns a codec with the named framing and compression working on the same stream as codec.
+// Bytes already buffered by codec are kept, so it is safe to switch between two frames.
+func Switch(codec Codec, name string, compression string) (Codec, error) {
+	if compressed, ok := codec.(*GzipCodec); ok {
+		codec = compressed.codec
+	}
+
+	s, ok := codec.(streamer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T can not be switched", ErrUnknownFraming, codec)
+	}
+
+	reader, writer, maxSize := s.stream()
+
+	var switched Codec
+
+	switch name {
+	case Newline:
+		switched = NewLineCodec(reader, writer, maxSize)
+	case LengthPrefixed:
+		switched = NewLengthPrefixedCodec(reader, writer, maxSize)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFraming, name)
+	}
+
+	switch {
+	case compression == Identity:
+		return switched, nil
+	case compression == Gzip && name == LengthPrefixed:
+		return NewGzipCodec(switched, maxSize), nil
+	default:
+		return nil, fmt.Errorf("%w: %s over %s framing", ErrUnknownCompression, compression, name)
+	}
+}
+
+// Preamble is sent by a client before its first length-prefixed frame.
+func Preamble() []byte {
+	return append([]byte(preambleMagic), Version)
+}
+
+// Accept detects the framing used by the client: a stream starting with the preamble uses
+// length-prefixed frames, anything else is the legacy newline-delimited framing.
+func Accept(rw io.ReadWriter, maxSize int) (Codec, error) {
+	readerSize := maxSize + 1
+	if readerSize < minReaderBytes {
+		readerSize = minReaderBytes
+	}
+
+	reader := bufio.NewReaderSize(rw, readerSize)
+
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("peek first byte failed: %w", err)
+	}
+
+	if first[0] != preambleMagic[0] {
+		return NewLineCodec(reader, rw, maxSize), nil
+	}
+
+	preamble := make([]byte, len(preambleMagic)+1)
+	if _, err = io.ReadFull(reader, preamble); err != nil {
+		return nil, fmt.Errorf("read preamble failed: %w", err)
+	}
+
+	if !bytes.Equal(preamble, Preamble()) {
+		return nil, fmt.Errorf("%w: preamble %x", ErrUnsupportedVersion, preamble)
+	}
+
+	return NewLengthPrefixedCodec(reader, rw, maxSize), nil
+}
+
+// Dial sends the preamble and returns a length-prefixed codec for the client side of the stream.
+func Dial(rw io.ReadWriter, maxSize int) (*LengthPrefixedCodec, error) {
+	if _, err := rw.Write(Preamble()); err != nil {
+		return nil, fmt.Errorf("write preamble failed: %w", err)
+	}
+
+	return NewLengthPrefixedCodec(bufio.NewReader(rw), rw, maxSize), nil
+}
+
+// LengthPrefixedCodec frames messages with their uvarint encoded length,
+// so a message may contain any bytes.
+type LengthPrefixedCodec struct {
+	reader  *bufio.Reader
+	writer  io.Writer
+	maxSize int
+}
+
+func NewLengthPrefixedCodec(reader *bufio.Reader, writer io.Writer, maxSize int) *LengthPrefixedCodec {
+	return &LengthPrefixedCodec{reader: reader, writer: writer, maxSize: maxSize}
+}
+
+// ReadFrame reads the next frame. Frames larger than maxSize are not read and return ErrFrameTooLarge.
+func (c *LengthPrefixedCodec) ReadFrame() ([]byte, error) {
+	size, err := binary.ReadUvarint(c.reader)
+	if err != nil {
+		return nil, fmt.Errorf("read frame size failed: %w", err)
+	}
+
+	if c.maxSize > 0 && size > uint64(c.maxSize) {
+		return nil, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, size)
+	}
+
+	frame := make([]byte, size)
+	if _, err = io.ReadFull(c.reader, frame); err != nil {
+		return nil, fmt.Errorf("read frame failed: %w", err)
+	}
+
+	return frame, nil
+}
+
+func (c *LengthPrefixedCodec) Name() string {
+	return LengthPrefixed
+}
+
+func (c *LengthPrefixedCodec) stream() (*bufio.Reader, io.Writer, int) {
+	return c.reader, c.writer, c.maxSize
+}
+
+func (c *LengthPrefixedCodec) WriteFrame(frame []byte) error {
+	data := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64+len(frame)), uint64(len(frame)))
+	data = append(data, frame...)
+
+	if _, err := c.writer.Write(data); err != nil {
+		return fmt.Errorf("write frame failed: %w", err)
+	}
+
+	return nil
+}
+
+// LineCodec is the legacy framing: every message is terminated by a newline.
+// It breaks messages containing a newline byte and is kept for old clients only.
+type LineCodec struct {
+	reader  *bufio.Reader
+	writer  io.Writer
+	maxSize int
+}
+
+func NewLineCodec(reader *bufio.Reader, writer io.Writer, maxSize int) *LineCodec {
+	return &LineCodec{reader: reader, writer: writer, maxSize: maxSize}
+}
+
+// ReadFrame reads up to the next newline. Lines of maxSize bytes or longer return ErrFrameTooLarge.
+func (c *LineCodec) ReadFrame() ([]byte, error) {
+	var frame []byte
+
+	for {
+		chunk, err := c.reader.ReadSlice(lineDelimiter)
+		frame = append(frame, chunk...)
+
+		if c.maxSize > 0 && len(frame) > c.maxSize {
+			return nil, fmt.Errorf("%w: more than %d bytes", ErrFrameTooLarge, c.maxSize)
+		}
+
+		switch {
+		case err == nil:
+			return frame[:len(frame)-1], nil
+		case errors.Is(err, bufio.ErrBufferFull):
+			continue
+		case errors.Is(err, io.EOF) && len(frame) > 0:
+			return frame, nil
+		default:
+			return nil, fmt.Errorf("read line failed: %w", err)
+		}
+	}
+}
+
+func (c *LineCodec) Name() string {
+	return Newline
+}
+
+func (c *LineCodec) stream() (*bufio.Reader, io.Writer, int) {
+	return c.reader, c.writer, c.maxSize
+}
+
+func (c *LineCodec) WriteFrame(frame []byte) error {
+	data := make([]byte, 0, len(frame)+1)
+	data = append(data, frame...)
+	data = append(data, lineDelimiter)
+
+	if _, err := c.writer.Write(data); err != nil {
+		return fmt.Errorf("write frame failed: %w", err)
+	}
+
+	return nil
+}
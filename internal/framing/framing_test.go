@@ -0,0 +1,195 @@
+package framing
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stream is a connection stand-in: reads come from in, writes go to out.
+type stream struct {
+	in  *bytes.Buffer
+	out *bytes.Buffer
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	return s.in.Read(p)
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	return s.out.Write(p)
+}
+
+func TestAccept(t *testing.T) {
+	t.Parallel()
+
+	lengthPrefixed := &bytes.Buffer{}
+	_ = NewLengthPrefixedCodec(nil, lengthPrefixed, 0).WriteFrame([]byte("first\nframe"))
+	_ = NewLengthPrefixedCodec(nil, lengthPrefixed, 0).WriteFrame([]byte{})
+
+	tests := []struct {
+		name    string
+		input   []byte
+		frames  []string
+		codec   Codec
+		wantErr error
+	}{
+		{
+			name:    "legacy newline framing",
+			input:   []byte("first\n\nthird"),
+			frames:  []string{"first", "", "third"},
+			codec:   &LineCodec{reader: nil, writer: nil, maxSize: 0},
+			wantErr: nil,
+		},
+		{
+			name:    "length-prefixed framing",
+			input:   append(Preamble(), lengthPrefixed.Bytes()...),
+			frames:  []string{"first\nframe", ""},
+			codec:   &LengthPrefixedCodec{reader: nil, writer: nil, maxSize: 0},
+			wantErr: nil,
+		},
+		{
+			name:    "unsupported version",
+			input:   append([]byte(preambleMagic), Version+1),
+			frames:  nil,
+			codec:   nil,
+			wantErr: ErrUnsupportedVersion,
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			codec, err := Accept(&stream{in: bytes.NewBuffer(tcCopy.input), out: &bytes.Buffer{}}, 64)
+			if tcCopy.wantErr != nil {
+				assert.ErrorIs(t, err, tcCopy.wantErr)
+
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.IsType(t, tcCopy.codec, codec)
+
+			for _, want := range tcCopy.frames {
+				frame, err := codec.ReadFrame()
+				assert.NoError(t, err)
+				assert.Equal(t, want, string(frame))
+			}
+
+			_, err = codec.ReadFrame()
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestDial(t *testing.T) {
+	t.Parallel()
+
+	rw := &stream{in: &bytes.Buffer{}, out: &bytes.Buffer{}}
+
+	client, err := Dial(rw, 64)
+	assert.NoError(t, err)
+	assert.NoError(t, client.WriteFrame([]byte("request")))
+
+	// the client output is accepted as a length-prefixed stream
+	server, err := Accept(&stream{in: rw.out, out: rw.in}, 64)
+	assert.NoError(t, err)
+
+	frame, err := server.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "request", string(frame))
+
+	assert.NoError(t, server.WriteFrame([]byte("response")))
+
+	frame, err = client.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "response", string(frame))
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	t.Parallel()
+
+	encoded := &bytes.Buffer{}
+	_ = NewLengthPrefixedCodec(nil, encoded, 0).WriteFrame(make([]byte, 65))
+
+	tests := []struct {
+		name  string
+		codec Reader
+	}{
+		{
+			name:  "newline framing",
+			codec: NewLineCodec(bufio.NewReaderSize(bytes.NewReader(append(make([]byte, 65), '\n')), 16), nil, 64),
+		},
+		{
+			name:  "length-prefixed framing",
+			codec: NewLengthPrefixedCodec(bufio.NewReader(encoded), nil, 64),
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := tcCopy.codec.ReadFrame()
+			assert.True(t, errors.Is(err, ErrFrameTooLarge), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestSwitch(t *testing.T) {
+	t.Parallel()
+
+	rw := &stream{in: &bytes.Buffer{}, out: &bytes.Buffer{}}
+
+	client, err := Dial(rw, 64)
+	assert.NoError(t, err)
+	assert.NoError(t, client.WriteFrame([]byte("hello")))
+
+	compressed, err := Switch(client, LengthPrefixed, Gzip)
+	assert.NoError(t, err)
+	assert.Equal(t, LengthPrefixed, compressed.Name())
+	assert.NoError(t, compressed.WriteFrame([]byte("compressed request")))
+
+	server, err := Accept(&stream{in: rw.out, out: rw.in}, 64)
+	assert.NoError(t, err)
+
+	frame, err := server.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(frame))
+
+	server, err = Switch(server, LengthPrefixed, Gzip)
+	assert.NoError(t, err)
+
+	frame, err = server.ReadFrame()
+	assert.NoError(t, err)
+	assert.Equal(t, "compressed request", string(frame))
+
+	// switching back drops the compression
+	server, err = Switch(server, Newline, Identity)
+	assert.NoError(t, err)
+	assert.IsType(t, &LineCodec{reader: nil, writer: nil, maxSize: 0}, server)
+
+	_, err = Switch(server, Newline, Gzip)
+	assert.ErrorIs(t, err, ErrUnknownCompression)
+
+	_, err = Switch(server, "xml", Identity)
+	assert.ErrorIs(t, err, ErrUnknownFraming)
+}
+
+func TestGzipFrameTooLarge(t *testing.T) {
+	t.Parallel()
+
+	encoded := &bytes.Buffer{}
+	_ = NewGzipCodec(NewLengthPrefixedCodec(nil, encoded, 0), 0).WriteFrame(make([]byte, 1024))
+
+	codec := NewGzipCodec(NewLengthPrefixedCodec(bufio.NewReader(encoded), nil, 64), 64)
+
+	_, err := codec.ReadFrame()
+	assert.ErrorIs(t, err, ErrFrameTooLarge)
+}
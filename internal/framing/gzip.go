@@ -0,0 +1,67 @@
+package framing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// GzipCodec compresses every frame of the underlying codec with gzip.
+type GzipCodec struct {
+	codec   Codec
+	maxSize int
+}
+
+func NewGzipCodec(codec Codec, maxSize int) *GzipCodec {
+	return &GzipCodec{codec: codec, maxSize: maxSize}
+}
+
+func (c *GzipCodec) Name() string {
+	return c.codec.Name()
+}
+
+// ReadFrame reads and decompresses the next frame. Frames decompressing to more than maxSize bytes
+// return ErrFrameTooLarge.
+func (c *GzipCodec) ReadFrame() ([]byte, error) {
+	frame, err := c.codec.ReadFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("gzip frame invalid: %w", err)
+	}
+
+	var reader io.Reader = zr
+	if c.maxSize > 0 {
+		reader = io.LimitReader(zr, int64(c.maxSize)+1)
+	}
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("decompress frame failed: %w", err)
+	}
+
+	if c.maxSize > 0 && len(data) > c.maxSize {
+		return nil, fmt.Errorf("%w: more than %d bytes decompressed", ErrFrameTooLarge, c.maxSize)
+	}
+
+	return data, nil
+}
+
+func (c *GzipCodec) WriteFrame(frame []byte) error {
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(frame); err != nil {
+		return fmt.Errorf("compress frame failed: %w", err)
+	}
+
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress frame failed: %w", err)
+	}
+
+	return c.codec.WriteFrame(buf.Bytes())
+}
@@ -14,10 +14,9 @@ import (
 
 // Constants related to hashcash.
 const (
-	difficulty        = 3       // difficulty level for hashcash computation
 	version           = 1       // hashcash ver
+	maxBits           = 256     // sha256 hash size in bits
 	maxIterations     = 1 << 30 // maximum number of iterations for solve challenge
-	hcStringParts     = 6       // expected number of parts in a hashcash string
 	hashcashRandInter = 1 << 30
 )
 
@@ -27,14 +26,27 @@ const (
 	strBitsIdx
 	strDateIdx
 	strResourceIdx
-	_ // strExtIdx
+	strExtIdx
 	strRandIdx
 	strCounterIdx
+
+	hcStringParts = strCounterIdx + 1 // expected number of parts in a hashcash string
+)
+
+// The resource is an IP address and IPv6 ones contain the separator of the stamp fields,
+// so it is escaped in the string form. IPv4 addresses and host names are written as they are.
+var (
+	resourceEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	resourceUnescaper = strings.NewReplacer("%25", "%", "%3A", ":", "%3a", ":")
 )
 
 var (
 	ErrMaxIterationsExceeded = errors.New("maximum number of iterations exceeded")
 	ErrInvalidHashcashString = errors.New("invalid input format")
+	ErrInvalidBits           = errors.New("invalid difficulty bits")
+	ErrChallengeMismatch     = errors.New("solution does not match issued challenge")
+	ErrWrongResource         = errors.New("solution resource does not match client")
+	ErrInsufficientWork      = errors.New("solution does not satisfy difficulty")
 )
 
 type Hashcash struct {
@@ -47,7 +59,13 @@ type Hashcash struct {
 	Counter  int
 }
 
-func NewHashcash(resource string) (*Hashcash, error) {
+// NewHashcash creates a challenge for the resource which requires bits leading zero bits
+// in the hash of a solution.
+func NewHashcash(resource string, bits int) (*Hashcash, error) {
+	if err := validateBits(bits); err != nil {
+		return nil, err
+	}
+
 	hcRand, err := newRandomForPOW()
 	if err != nil {
 		return nil, fmt.Errorf("make random failed: %w", err)
@@ -55,7 +73,7 @@ func NewHashcash(resource string) (*Hashcash, error) {
 
 	return &Hashcash{
 		Version:  version,
-		Bits:     difficulty,
+		Bits:     bits,
 		Date:     time.Now().UTC(),
 		Resource: resource,
 		Ext:      "",
@@ -81,13 +99,14 @@ func newRandomForPOW() (*big.Int, error) {
 func NewHashcashFromString(hcStr string) (*Hashcash, error) {
 	parts := strings.Split(hcStr, ":")
 	if len(parts) < hcStringParts {
-		return nil, fmt.Errorf("%w: expected at least 6 parts, got %d", ErrInvalidHashcashString, len(parts))
+		return nil, fmt.Errorf("%w: expected at least %d parts, got %d", ErrInvalidHashcashString, hcStringParts, len(parts))
 	}
 
 	verPart := parts[strVersionIdx]
 	bitsPart := parts[strBitsIdx]
 	datePart := parts[strDateIdx]
 	resourcePart := parts[strResourceIdx]
+	extPart := parts[strExtIdx]
 	randPart := parts[strRandIdx]
 	counterPart := parts[strCounterIdx]
 
@@ -101,6 +120,10 @@ func NewHashcashFromString(hcStr string) (*Hashcash, error) {
 		return nil, fmt.Errorf("parse bits %s failed: %w", bitsPart, err)
 	}
 
+	if err = validateBits(bits); err != nil {
+		return nil, err
+	}
+
 	dateTimestamp, err := strconv.ParseInt(datePart, 10, 64)
 	if err != nil {
 		return nil, fmt.Errorf("parse date %s failed: %w", datePart, err)
@@ -122,8 +145,8 @@ func NewHashcashFromString(hcStr string) (*Hashcash, error) {
 		Version:  ver,
 		Bits:     bits,
 		Date:     date,
-		Resource: resourcePart,
-		Ext:      "",
+		Resource: resourceUnescaper.Replace(resourcePart),
+		Ext:      extPart,
 		Rand:     hcRand,
 		Counter:  counter,
 	}
@@ -132,13 +155,13 @@ func NewHashcashFromString(hcStr string) (*Hashcash, error) {
 }
 
 // ToString returns a string representation of the Hashcash structure.
-// String format "version:bits:date:resource:ext:rand:counter".
+// String format "version:bits:date:resource:ext:rand:counter", with ":" in the resource escaped as "%3A".
 func (h *Hashcash) ToString() string {
 	return fmt.Sprintf("%d:%d:%d:%s:%s:%d:%d",
 		h.Version,
 		h.Bits,
 		h.Date.Unix(),
-		h.Resource,
+		resourceEscaper.Replace(h.Resource),
 		h.Ext,
 		h.Rand,
 		h.Counter,
@@ -147,24 +170,29 @@ func (h *Hashcash) ToString() string {
 
 // ValidateSolution checks if the solution for the Hashcash challenge is valid.
 // It constructs the challenge by combining the Hashcash and counter, calculates the hash,
-// and checks if it has the required leading zeros. Returns true if valid; otherwise, false.
+// and checks if it has the required leading zero bits. Returns true if valid; otherwise, false.
 func (h *Hashcash) ValidateSolution() bool {
-	challenge := fmt.Sprintf("%s%d", h.ToString(), h.Counter)
-	hash := sha256.Sum256([]byte(challenge))
-	hashStr := hex.EncodeToString(hash[:])
+	if validateBits(h.Bits) != nil {
+		return false
+	}
+
+	hash := sha256.Sum256([]byte(h.ToString() + strconv.Itoa(h.Counter)))
 
-	return strings.HasPrefix(hashStr, strings.Repeat("0", difficulty))
+	return hasLeadingZeroBits(hash[:], h.Bits)
 }
 
 // SolveChallenge tries to find a valid solution for the challenge by incrementing the counter and computing the hash.
 // Returns nil if a valid solution is found
 // or ErrMaxIterationsExceeded if the maximum number of iterations is reached without finding a solution.
 func (h *Hashcash) SolveChallenge() error {
+	if err := validateBits(h.Bits); err != nil {
+		return err
+	}
+
 	for i := 0; i < maxIterations; i++ {
 		hash := sha256.Sum256([]byte(h.ToString() + strconv.Itoa(h.Counter)))
-		hashStr := hex.EncodeToString(hash[:])
 
-		if strings.HasPrefix(hashStr, strings.Repeat("0", difficulty)) {
+		if hasLeadingZeroBits(hash[:], h.Bits) {
 			return nil
 		}
 		h.Counter++
@@ -172,3 +200,86 @@ func (h *Hashcash) SolveChallenge() error {
 
 	return ErrMaxIterationsExceeded
 }
+
+// Stamp returns the hashcash stamp itself.
+func (h *Hashcash) Stamp() *Hashcash {
+	return h
+}
+
+// matches checks that every field of h except the counter is equal to the issued one
+// and that h was created for the resource.
+func (h *Hashcash) matches(issued *Hashcash, resource string) error {
+	if h.Resource != resource {
+		return fmt.Errorf("%w: got %q", ErrWrongResource, h.Resource)
+	}
+
+	switch {
+	case h.Version != issued.Version:
+		return fmt.Errorf("%w: version", ErrChallengeMismatch)
+	case h.Bits != issued.Bits:
+		return fmt.Errorf("%w: bits", ErrChallengeMismatch)
+	case h.Date.Unix() != issued.Date.Unix():
+		return fmt.Errorf("%w: date", ErrChallengeMismatch)
+	case h.Resource != issued.Resource:
+		return fmt.Errorf("%w: resource", ErrChallengeMismatch)
+	case h.Ext != issued.Ext:
+		return fmt.Errorf("%w: ext", ErrChallengeMismatch)
+	case h.Rand != issued.Rand:
+		return fmt.Errorf("%w: rand", ErrChallengeMismatch)
+	}
+
+	return nil
+}
+
+// Fingerprint identifies the challenge of the stamp: the hash of every field except the counter,
+// so all solutions of one challenge share a fingerprint.
+func (h *Hashcash) Fingerprint() string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d:%d:%d:%s:%s:%d",
+		h.Version,
+		h.Bits,
+		h.Date.Unix(),
+		h.Resource,
+		h.Ext,
+		h.Rand,
+	)))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Algorithm returns the proof-of-work algorithm announced in the Ext field,
+// stamps without one are plain SHA-256 hashcash.
+func (h *Hashcash) Algorithm() string {
+	if alg := extValue(h.Ext, extAlgorithm); alg != "" {
+		return alg
+	}
+
+	return AlgorithmSHA256
+}
+
+// hasLeadingZeroBits reports whether the hash starts with at least bits zero bits.
+func hasLeadingZeroBits(hash []byte, bits int) bool {
+	for _, b := range hash {
+		if bits <= 0 {
+			return true
+		}
+
+		if bits < 8 {
+			return b>>(8-bits) == 0
+		}
+
+		if b != 0 {
+			return false
+		}
+		bits -= 8
+	}
+
+	return bits <= 0
+}
+
+func validateBits(bits int) error {
+	if bits < 0 || bits > maxBits {
+		return fmt.Errorf("%w: %d not in [0, %d]", ErrInvalidBits, bits, maxBits)
+	}
+
+	return nil
+}
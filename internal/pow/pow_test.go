@@ -1,17 +1,23 @@
 package pow
 
 import (
+	"crypto/sha256"
+	"errors"
 	"math/big"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+const testBits = 12
+
 func TestSolveChallenge(t *testing.T) {
 	t.Parallel()
 
-	hc, _ := NewHashcash("test")
+	hc, _ := NewHashcash("test", testBits)
 
 	err := hc.SolveChallenge()
 	assert.Nil(t, err, "Expected no error in solving the challenge")
@@ -23,7 +29,7 @@ func TestSolveChallenge(t *testing.T) {
 func TestValidateSolution(t *testing.T) {
 	t.Parallel()
 
-	hashcash, _ := NewHashcash("test")
+	hashcash, _ := NewHashcash("test", testBits)
 
 	hashcash.Counter = 123
 	if invalid := hashcash.ValidateSolution(); invalid {
@@ -39,6 +45,61 @@ func TestValidateSolution(t *testing.T) {
 	}
 }
 
+func TestSolveChallengeBits(t *testing.T) {
+	t.Parallel()
+
+	for _, bits := range []int{0, 1, 7, 8, 9, 15} {
+		hc, err := NewHashcash("test", bits)
+		assert.Nil(t, err, "Expected no error in creating Hashcash")
+
+		err = hc.SolveChallenge()
+		assert.Nil(t, err, "Expected no error in solving the challenge")
+
+		hash := sha256.Sum256([]byte(hc.ToString() + strconv.Itoa(hc.Counter)))
+		assert.True(t, hasLeadingZeroBits(hash[:], bits), "Expected %d leading zero bits", bits)
+	}
+}
+
+func TestNewHashcashInvalidBits(t *testing.T) {
+	t.Parallel()
+
+	for _, bits := range []int{-1, maxBits + 1} {
+		_, err := NewHashcash("test", bits)
+		assert.ErrorIs(t, err, ErrInvalidBits)
+	}
+
+	_, err := NewHashcashFromString("1:300:1625075186:test::123456:0")
+	assert.ErrorIs(t, err, ErrInvalidBits)
+}
+
+func TestHasLeadingZeroBits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		hash     []byte
+		bits     int
+		expected bool
+	}{
+		{name: "zero bits", hash: []byte{0xff}, bits: 0, expected: true},
+		{name: "partial byte", hash: []byte{0x0f, 0xff}, bits: 4, expected: true},
+		{name: "partial byte too few", hash: []byte{0x1f, 0xff}, bits: 4, expected: false},
+		{name: "whole byte", hash: []byte{0x00, 0xff}, bits: 8, expected: true},
+		{name: "across bytes", hash: []byte{0x00, 0x3f}, bits: 10, expected: true},
+		{name: "across bytes too few", hash: []byte{0x00, 0x7f}, bits: 10, expected: false},
+		{name: "longer than hash", hash: []byte{0x00}, bits: 9, expected: false},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tcCopy.expected, hasLeadingZeroBits(tcCopy.hash, tcCopy.bits))
+		})
+	}
+}
+
 func TestNewHashcashFromString(t *testing.T) {
 	t.Parallel()
 
@@ -51,6 +112,7 @@ func TestNewHashcashFromString(t *testing.T) {
 	assert.Equal(t, 20, hashcash.Bits)
 	assert.Equal(t, time.Unix(1625075186, 0), hashcash.Date)
 	assert.Equal(t, "test", hashcash.Resource)
+	assert.Equal(t, "", hashcash.Ext)
 	assert.Equal(t, 123456, int(hashcash.Rand))
 	assert.Equal(t, 0, hashcash.Counter)
 }
@@ -74,6 +136,27 @@ func TestToString(t *testing.T) {
 	assert.Equal(t, expected, actual, "Expected string representation to match")
 }
 
+func TestIPv6Resource(t *testing.T) {
+	t.Parallel()
+
+	hashcash, err := NewHashcash("2001:db8::1", 4)
+	require.NoError(t, err)
+
+	assert.Contains(t, hashcash.ToString(), ":2001%3Adb8%3A%3A1:")
+
+	parsed, err := NewHashcashFromString(hashcash.ToString())
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::1", parsed.Resource)
+	assert.Equal(t, hashcash.ToString(), parsed.ToString())
+
+	// a zone keeps its percent sign
+	hashcash.Resource = "fe80::1%eth0"
+
+	parsed, err = NewHashcashFromString(hashcash.ToString())
+	require.NoError(t, err)
+	assert.Equal(t, "fe80::1%eth0", parsed.Resource)
+}
+
 func TestNewRandomForPOW(t *testing.T) {
 	t.Parallel()
 
@@ -101,3 +184,58 @@ func TestNewRandomForPOW(t *testing.T) {
 		t.Errorf("newRandomForPOW() should generate a number in range [0, hashcashRandInter), got: %v", rand2)
 	}
 }
+
+func TestVerify(t *testing.T) {
+	t.Parallel()
+
+	issued, _ := NewHashcash("127.0.0.1", testBits)
+
+	solved := *issued
+	err := solved.SolveChallenge()
+	assert.Nil(t, err, "Expected no error in solving the challenge")
+
+	tests := []struct {
+		name     string
+		forge    func(hc *Hashcash)
+		resource string
+		wantErr  error
+	}{
+		{name: "valid", forge: func(hc *Hashcash) {}, resource: "127.0.0.1", wantErr: nil},
+		{name: "wrong resource", forge: func(hc *Hashcash) {}, resource: "10.0.0.1", wantErr: ErrWrongResource},
+		{name: "version", forge: func(hc *Hashcash) { hc.Version++ }, resource: "127.0.0.1", wantErr: ErrChallengeMismatch},
+		{name: "bits", forge: func(hc *Hashcash) { hc.Bits-- }, resource: "127.0.0.1", wantErr: ErrChallengeMismatch},
+		{
+			name:     "date",
+			forge:    func(hc *Hashcash) { hc.Date = hc.Date.Add(time.Second) },
+			resource: "127.0.0.1",
+			wantErr:  ErrChallengeMismatch,
+		},
+		{name: "ext", forge: func(hc *Hashcash) { hc.Ext = "x" }, resource: "127.0.0.1", wantErr: ErrChallengeMismatch},
+		{name: "rand", forge: func(hc *Hashcash) { hc.Rand++ }, resource: "127.0.0.1", wantErr: ErrChallengeMismatch},
+		{
+			name: "insufficient work",
+			forge: func(hc *Hashcash) {
+				for hc.ValidateSolution() {
+					hc.Counter++
+				}
+			},
+			resource: "127.0.0.1",
+			wantErr:  ErrInsufficientWork,
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			hc := solved
+			tcCopy.forge(&hc)
+
+			err := testVerifier.Verify(&hc, issued, tcCopy.resource)
+			if !errors.Is(err, tcCopy.wantErr) {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tcCopy.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package pow
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"zenquote/internal/config"
+)
+
+const (
+	AlgorithmSHA256 = "sha256"
+	AlgorithmScrypt = "scrypt"
+)
+
+// Hashcash Ext field is a list of "name=value" pairs separated by ';'.
+const (
+	extAlgorithm = "alg"
+	extSep       = ";"
+	extKVSep     = "="
+)
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown pow algorithm")
+	ErrWeakerAlgorithm  = errors.New("pow algorithm cheaper than the default")
+)
+
+// Puzzle is a proof-of-work challenge. The challenge fields are carried by a hashcash stamp,
+// implementations differ in the hash function the work is done with.
+type Puzzle interface {
+	// Stamp returns the hashcash stamp of the puzzle.
+	Stamp() *Hashcash
+	// ToString serializes the puzzle, the algorithm is announced in the stamp Ext field.
+	ToString() string
+	// SolveChallenge searches for a counter satisfying the difficulty.
+	SolveChallenge() error
+	// ValidateSolution checks that the counter satisfies the difficulty.
+	ValidateSolution() bool
+}
+
+// Challenger issues and parses puzzles of a single algorithm.
+type Challenger interface {
+	Algorithm() string
+	Issue(resource string, bits int) (Puzzle, error)
+	Parse(challenge string) (Puzzle, error)
+}
+
+// NewChallenger returns the challenger for the configured algorithm.
+func NewChallenger(cfg config.Config) (Challenger, error) {
+	return newChallenger(cfg, cfg.Pow.Algorithm)
+}
+
+func newChallenger(cfg config.Config, alg string) (Challenger, error) {
+	switch alg {
+	case AlgorithmSHA256, "":
+		return SHA256Challenger{}, nil
+	case AlgorithmScrypt:
+		return NewScryptChallenger(cfg.Pow.Scrypt)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, alg)
+	}
+}
+
+// Challengers are the challengers a client can choose from, in server preference order.
+// The first one is the configured algorithm, it is used for clients that do not negotiate.
+type Challengers []Challenger
+
+// NewChallengers returns the configured algorithm followed by the extra algorithms clients may select.
+// The extra algorithms must cost at least as much as the configured one, or clients could opt out of it.
+func NewChallengers(cfg config.Config) (Challengers, error) {
+	challengers := make(Challengers, 0, len(cfg.Pow.Algorithms)+1)
+
+	for _, alg := range append([]string{cfg.Pow.Algorithm}, cfg.Pow.Algorithms...) {
+		challenger, err := newChallenger(cfg, alg)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(challengers) > 0 && costRank(challenger.Algorithm()) < costRank(challengers.Default().Algorithm()) {
+			return nil, fmt.Errorf("%w: %s below %s", ErrWeakerAlgorithm, challenger.Algorithm(),
+				challengers.Default().Algorithm())
+		}
+
+		if challengers.Get(challenger.Algorithm()) == nil {
+			challengers = append(challengers, challenger)
+		}
+	}
+
+	return challengers, nil
+}
+
+// Default returns the challenger for clients that did not negotiate an algorithm.
+func (c Challengers) Default() Challenger {
+	return c[0]
+}
+
+// Get returns the challenger of the algorithm, nil if the algorithm is not enabled.
+func (c Challengers) Get(alg string) Challenger {
+	for _, challenger := range c {
+		if challenger.Algorithm() == alg {
+			return challenger
+		}
+	}
+
+	return nil
+}
+
+// Algorithms returns the enabled algorithms in preference order.
+func (c Challengers) Algorithms() []string {
+	algorithms := make([]string, 0, len(c))
+	for _, challenger := range c {
+		algorithms = append(algorithms, challenger.Algorithm())
+	}
+
+	return algorithms
+}
+
+// Negotiate returns the most preferred challenger among the algorithms offered by a client.
+// Algorithms cheaper than the default are never chosen, so a client can not downgrade below it.
+// A client that offers nothing gets the default challenger.
+func (c Challengers) Negotiate(offered []string) (Challenger, bool) {
+	if len(offered) == 0 {
+		return c.Default(), true
+	}
+
+	minRank := costRank(c.Default().Algorithm())
+
+	for _, challenger := range c {
+		if costRank(challenger.Algorithm()) < minRank {
+			continue
+		}
+
+		for _, alg := range offered {
+			if challenger.Algorithm() == alg {
+				return challenger, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// costRank orders the algorithms by what solving them costs on hardware an attacker may have:
+// memory-hard puzzles rank above plain hashing, which GPUs and ASICs accelerate.
+func costRank(alg string) int {
+	switch alg {
+	case AlgorithmScrypt:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ParsePuzzle parses a serialized puzzle of any supported algorithm.
+func ParsePuzzle(challenge string) (Puzzle, error) {
+	hashcash, err := NewHashcashFromString(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg := hashcash.Algorithm(); alg {
+	case AlgorithmSHA256:
+		return hashcash, nil
+	case AlgorithmScrypt:
+		return newScryptFromHashcash(hashcash)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownAlgorithm, alg)
+	}
+}
+
+// SHA256Challenger issues classic SHA-256 hashcash puzzles.
+type SHA256Challenger struct{}
+
+func (SHA256Challenger) Algorithm() string {
+	return AlgorithmSHA256
+}
+
+func (SHA256Challenger) Issue(resource string, bits int) (Puzzle, error) {
+	return NewHashcash(resource, bits)
+}
+
+func (c SHA256Challenger) Parse(challenge string) (Puzzle, error) {
+	return parseAlgorithm(c, challenge)
+}
+
+func parseAlgorithm(c Challenger, challenge string) (Puzzle, error) {
+	puzzle, err := ParsePuzzle(challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	if alg := puzzle.Stamp().Algorithm(); alg != c.Algorithm() {
+		return nil, fmt.Errorf("%w: expected %s, got %s", ErrUnknownAlgorithm, c.Algorithm(), alg)
+	}
+
+	return puzzle, nil
+}
+
+// extValue returns the value of the named Ext field entry or an empty string.
+func extValue(ext string, name string) string {
+	for _, kv := range strings.Split(ext, extSep) {
+		if key, val, ok := strings.Cut(kv, extKVSep); ok && key == name {
+			return val
+		}
+	}
+
+	return ""
+}
+
+// formatExt joins name, value pairs into an Ext field.
+func formatExt(kvs ...string) string {
+	entries := make([]string, 0, len(kvs)/2)
+	for i := 0; i+1 < len(kvs); i += 2 {
+		entries = append(entries, kvs[i]+extKVSep+kvs[i+1])
+	}
+
+	return strings.Join(entries, extSep)
+}
+
+// joinExt appends entries to an Ext field.
+func joinExt(ext string, entries string) string {
+	if ext == "" {
+		return entries
+	}
+
+	return ext + extSep + entries
+}
+
+// withoutExt returns the Ext field without the named entry.
+func withoutExt(ext string, name string) string {
+	entries := strings.Split(ext, extSep)
+	kept := entries[:0]
+
+	for _, kv := range entries {
+		if key, _, _ := strings.Cut(kv, extKVSep); key != name {
+			kept = append(kept, kv)
+		}
+	}
+
+	return strings.Join(kept, extSep)
+}
@@ -0,0 +1,181 @@
+package pow
+
+import (
+	"testing"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChallenger(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		algorithm string
+		expected  string
+		wantErr   error
+	}{
+		{name: "default", algorithm: "", expected: AlgorithmSHA256, wantErr: nil},
+		{name: "sha256", algorithm: AlgorithmSHA256, expected: AlgorithmSHA256, wantErr: nil},
+		{name: "scrypt", algorithm: AlgorithmScrypt, expected: AlgorithmScrypt, wantErr: nil},
+		{name: "unknown", algorithm: "md5", expected: "", wantErr: ErrUnknownAlgorithm},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{}
+			cfg.Pow.Algorithm = tcCopy.algorithm
+			cfg.Pow.Scrypt = testScryptConfig
+
+			challenger, err := NewChallenger(cfg)
+			if tcCopy.wantErr != nil {
+				assert.ErrorIs(t, err, tcCopy.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tcCopy.expected, challenger.Algorithm())
+		})
+	}
+}
+
+func TestChallengersNegotiate(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.Pow.Algorithm = AlgorithmSHA256
+	cfg.Pow.Algorithms = []string{AlgorithmScrypt, AlgorithmSHA256}
+	cfg.Pow.Scrypt = testScryptConfig
+
+	challengers, err := NewChallengers(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{AlgorithmSHA256, AlgorithmScrypt}, challengers.Algorithms())
+
+	tests := []struct {
+		name     string
+		offered  []string
+		expected string
+		ok       bool
+	}{
+		{name: "nothing offered", offered: nil, expected: AlgorithmSHA256, ok: true},
+		{name: "server preference wins", offered: []string{AlgorithmScrypt, AlgorithmSHA256}, expected: AlgorithmSHA256, ok: true},
+		{name: "only scrypt", offered: []string{"md5", AlgorithmScrypt}, expected: AlgorithmScrypt, ok: true},
+		{name: "no common algorithm", offered: []string{"md5"}, expected: "", ok: false},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			challenger, ok := challengers.Negotiate(tcCopy.offered)
+			assert.Equal(t, tcCopy.ok, ok)
+
+			if ok {
+				assert.Equal(t, tcCopy.expected, challenger.Algorithm())
+			}
+		})
+	}
+
+	cfg.Pow.Algorithms = []string{"md5"}
+	_, err = NewChallengers(cfg)
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestChallengersNegotiateDowngrade(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.Pow.Algorithm = AlgorithmScrypt
+	cfg.Pow.Algorithms = []string{AlgorithmSHA256}
+	cfg.Pow.Scrypt = testScryptConfig
+
+	// a cheaper extra algorithm is a config error
+	_, err := NewChallengers(cfg)
+	assert.ErrorIs(t, err, ErrWeakerAlgorithm)
+
+	scryptChallenger, err := NewScryptChallenger(testScryptConfig)
+	require.NoError(t, err)
+
+	// and is never chosen for a client offering only it
+	challengers := Challengers{scryptChallenger, SHA256Challenger{}}
+
+	_, ok := challengers.Negotiate([]string{AlgorithmSHA256})
+	assert.False(t, ok)
+
+	challenger, ok := challengers.Negotiate([]string{AlgorithmSHA256, AlgorithmScrypt})
+	assert.True(t, ok)
+	assert.Equal(t, AlgorithmScrypt, challenger.Algorithm())
+}
+
+func TestParsePuzzle(t *testing.T) {
+	t.Parallel()
+
+	puzzle, err := ParsePuzzle("1:20:1625075186:test::123456:0")
+	require.NoError(t, err)
+	assert.IsType(t, &Hashcash{}, puzzle)
+
+	puzzle, err = ParsePuzzle("1:4:1625075186:test:alg=scrypt;n=1024;r=1;p=1:123456:0")
+	require.NoError(t, err)
+	assert.IsType(t, &Scrypt{}, puzzle)
+	assert.Equal(t, AlgorithmScrypt, puzzle.Stamp().Algorithm())
+
+	_, err = ParsePuzzle("1:4:1625075186:test:alg=md5:123456:0")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+
+	// stamps missing the counter or everything are rejected, not indexed past their end
+	for _, challenge := range []string{"1:2:3:4:5:6", ""} {
+		_, err = ParsePuzzle(challenge)
+		assert.ErrorIs(t, err, ErrInvalidHashcashString)
+	}
+}
+
+func TestChallengerParse(t *testing.T) {
+	t.Parallel()
+
+	scryptChallenger, err := NewScryptChallenger(testScryptConfig)
+	require.NoError(t, err)
+
+	puzzle, err := scryptChallenger.Issue("test", testBits)
+	require.NoError(t, err)
+
+	_, err = scryptChallenger.Parse(puzzle.ToString())
+	assert.NoError(t, err)
+
+	_, err = SHA256Challenger{}.Parse(puzzle.ToString())
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
+
+func TestVerifyAlgorithmDowngrade(t *testing.T) {
+	t.Parallel()
+
+	scryptChallenger, err := NewScryptChallenger(testScryptConfig)
+	require.NoError(t, err)
+
+	issued, err := scryptChallenger.Issue("test", testBits)
+	require.NoError(t, err)
+
+	// the same challenge solved with the cheap algorithm must not be accepted
+	downgraded := *issued.Stamp()
+	downgraded.Ext = ""
+	require.NoError(t, downgraded.SolveChallenge())
+
+	assert.ErrorIs(t, testVerifier.Verify(&downgraded, issued, "test"), ErrChallengeMismatch)
+}
+
+func TestExt(t *testing.T) {
+	t.Parallel()
+
+	ext := formatExt("alg", "scrypt", "n", "1024")
+	assert.Equal(t, "alg=scrypt;n=1024", ext)
+	assert.Equal(t, "scrypt", extValue(ext, "alg"))
+	assert.Equal(t, "1024", extValue(ext, "n"))
+	assert.Equal(t, "", extValue(ext, "r"))
+	assert.Equal(t, "", extValue("", "alg"))
+}
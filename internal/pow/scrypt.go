@@ -0,0 +1,150 @@
+package pow
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"zenquote/internal/config"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Scrypt Ext field entries and limits on parameters accepted from a challenge string.
+const (
+	extScryptN     = "n"
+	extScryptR     = "r"
+	extScryptP     = "p"
+	maxScryptN     = 1 << 20
+	maxScryptRP    = 1 << 10
+	scryptKeyBytes = 32
+)
+
+var ErrInvalidScryptParams = errors.New("invalid scrypt params")
+
+// Scrypt is a memory-hard puzzle: every attempt costs an scrypt key derivation
+// instead of a SHA-256 hash, which takes away most of the advantage of GPUs and ASICs.
+type Scrypt struct {
+	*Hashcash
+	n int
+	r int
+	p int
+}
+
+func newScryptFromHashcash(hashcash *Hashcash) (*Scrypt, error) {
+	n, errN := strconv.Atoi(extValue(hashcash.Ext, extScryptN))
+	r, errR := strconv.Atoi(extValue(hashcash.Ext, extScryptR))
+	p, errP := strconv.Atoi(extValue(hashcash.Ext, extScryptP))
+
+	if err := errors.Join(errN, errR, errP); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidScryptParams, err)
+	}
+
+	if err := validateScryptParams(n, r, p); err != nil {
+		return nil, err
+	}
+
+	return &Scrypt{Hashcash: hashcash, n: n, r: r, p: p}, nil
+}
+
+// ValidateSolution checks that the scrypt key of the stamp has the required leading zero bits.
+func (s *Scrypt) ValidateSolution() bool {
+	if validateBits(s.Bits) != nil {
+		return false
+	}
+
+	key, err := s.key()
+	if err != nil {
+		return false
+	}
+
+	return hasLeadingZeroBits(key, s.Bits)
+}
+
+// SolveChallenge searches for a counter whose scrypt key has the required leading zero bits.
+func (s *Scrypt) SolveChallenge() error {
+	if err := validateBits(s.Bits); err != nil {
+		return err
+	}
+
+	for i := 0; i < maxIterations; i++ {
+		key, err := s.key()
+		if err != nil {
+			return err
+		}
+
+		if hasLeadingZeroBits(key, s.Bits) {
+			return nil
+		}
+		s.Counter++
+	}
+
+	return ErrMaxIterationsExceeded
+}
+
+func (s *Scrypt) key() ([]byte, error) {
+	password := []byte(s.ToString() + strconv.Itoa(s.Counter))
+	salt := []byte(strconv.FormatInt(s.Rand, 10))
+
+	key, err := scrypt.Key(password, salt, s.n, s.r, s.p, scryptKeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("scrypt key failed: %w", err)
+	}
+
+	return key, nil
+}
+
+// ScryptChallenger issues scrypt puzzles. A single scrypt attempt costs orders of magnitude
+// more than a SHA-256 one, so BitsDiscount bits are taken off the requested difficulty.
+type ScryptChallenger struct {
+	cfg config.Scrypt
+}
+
+func NewScryptChallenger(cfg config.Scrypt) (*ScryptChallenger, error) {
+	if err := validateScryptParams(cfg.N, cfg.R, cfg.P); err != nil {
+		return nil, err
+	}
+
+	return &ScryptChallenger{cfg: cfg}, nil
+}
+
+func (c *ScryptChallenger) Algorithm() string {
+	return AlgorithmScrypt
+}
+
+func (c *ScryptChallenger) Issue(resource string, bits int) (Puzzle, error) {
+	bits -= c.cfg.BitsDiscount
+	if bits < 0 {
+		bits = 0
+	}
+
+	hashcash, err := NewHashcash(resource, bits)
+	if err != nil {
+		return nil, err
+	}
+
+	hashcash.Ext = formatExt(
+		extAlgorithm, AlgorithmScrypt,
+		extScryptN, strconv.Itoa(c.cfg.N),
+		extScryptR, strconv.Itoa(c.cfg.R),
+		extScryptP, strconv.Itoa(c.cfg.P),
+	)
+
+	return &Scrypt{Hashcash: hashcash, n: c.cfg.N, r: c.cfg.R, p: c.cfg.P}, nil
+}
+
+func (c *ScryptChallenger) Parse(challenge string) (Puzzle, error) {
+	return parseAlgorithm(c, challenge)
+}
+
+// validateScryptParams bounds the parameters so a crafted challenge can not exhaust memory.
+func validateScryptParams(n, r, p int) error {
+	if n <= 1 || n&(n-1) != 0 || n > maxScryptN {
+		return fmt.Errorf("%w: n=%d must be a power of two in (1, %d]", ErrInvalidScryptParams, n, maxScryptN)
+	}
+
+	if r <= 0 || p <= 0 || r*p > maxScryptRP {
+		return fmt.Errorf("%w: r=%d p=%d", ErrInvalidScryptParams, r, p)
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package pow
+
+import (
+	"testing"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testScryptConfig = config.Scrypt{N: 1024, R: 1, P: 1, BitsDiscount: 8}
+
+func TestScryptSolveChallenge(t *testing.T) {
+	t.Parallel()
+
+	challenger, err := NewScryptChallenger(testScryptConfig)
+	require.NoError(t, err)
+
+	issued, err := challenger.Issue("test", testBits)
+	require.NoError(t, err)
+	assert.Equal(t, testBits-testScryptConfig.BitsDiscount, issued.Stamp().Bits)
+
+	solution, err := ParsePuzzle(issued.ToString())
+	require.NoError(t, err)
+
+	require.NoError(t, solution.SolveChallenge())
+	assert.True(t, solution.ValidateSolution(), "Expected solution to be valid")
+	assert.NoError(t, testVerifier.Verify(solution, issued, "test"))
+}
+
+func TestScryptValidateSolution(t *testing.T) {
+	t.Parallel()
+
+	challenger, err := NewScryptChallenger(config.Scrypt{N: 1024, R: 1, P: 1, BitsDiscount: 0})
+	require.NoError(t, err)
+
+	puzzle, err := challenger.Issue("test", testBits)
+	require.NoError(t, err)
+
+	// find a counter that does not satisfy the difficulty
+	for puzzle.ValidateSolution() {
+		puzzle.Stamp().Counter++
+	}
+
+	assert.ErrorIs(t, testVerifier.Verify(puzzle, puzzle, "test"), ErrInsufficientWork)
+}
+
+func TestScryptParams(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     config.Scrypt
+		wantErr bool
+	}{
+		{name: "valid", cfg: config.Scrypt{N: 16384, R: 8, P: 1, BitsDiscount: 0}, wantErr: false},
+		{name: "n not power of two", cfg: config.Scrypt{N: 1000, R: 8, P: 1, BitsDiscount: 0}, wantErr: true},
+		{name: "n too large", cfg: config.Scrypt{N: maxScryptN << 1, R: 8, P: 1, BitsDiscount: 0}, wantErr: true},
+		{name: "zero r", cfg: config.Scrypt{N: 1024, R: 0, P: 1, BitsDiscount: 0}, wantErr: true},
+		{name: "r*p too large", cfg: config.Scrypt{N: 1024, R: 1024, P: 2, BitsDiscount: 0}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := NewScryptChallenger(tcCopy.cfg)
+			if tcCopy.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidScryptParams)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// crafted challenge strings are rejected before any work is done
+	_, err := ParsePuzzle("1:4:1625075186:test:alg=scrypt;n=1073741824;r=8;p=1:123456:0")
+	assert.ErrorIs(t, err, ErrInvalidScryptParams)
+
+	_, err = ParsePuzzle("1:4:1625075186:test:alg=scrypt:123456:0")
+	assert.ErrorIs(t, err, ErrInvalidScryptParams)
+}
@@ -0,0 +1,103 @@
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"zenquote/internal/config"
+)
+
+// Ext field entry carrying the challenge signature as "keyID.base64(hmac)".
+const (
+	extMAC    = "mac"
+	macKeySep = "."
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid challenge signature")
+	ErrInvalidSignerKey = errors.New("invalid signer key")
+)
+
+// Signer signs challenges with HMAC-SHA256 so they can be verified without storing them.
+// Only the active key signs new challenges, the other keys are accepted for verification
+// which allows rotating keys without invalidating challenges already issued.
+type Signer struct {
+	enabled   bool
+	activeKey string
+	keys      map[string][]byte
+}
+
+func NewSigner(cfg config.Config) (*Signer, error) {
+	scfg := cfg.Pow.Stateless
+
+	keys := make(map[string][]byte, len(scfg.Keys))
+
+	for id, secret := range scfg.Keys {
+		if id == "" || strings.ContainsAny(id, macKeySep+extSep+extKVSep+":") {
+			return nil, fmt.Errorf("%w: id %q", ErrInvalidSignerKey, id)
+		}
+
+		if secret == "" {
+			return nil, fmt.Errorf("%w: empty secret for %q", ErrInvalidSignerKey, id)
+		}
+
+		keys[id] = []byte(secret)
+	}
+
+	if _, ok := keys[scfg.ActiveKey]; scfg.Enabled && !ok {
+		return nil, fmt.Errorf("%w: active key %q not found", ErrInvalidSignerKey, scfg.ActiveKey)
+	}
+
+	return &Signer{enabled: scfg.Enabled, activeKey: scfg.ActiveKey, keys: keys}, nil
+}
+
+// Enabled reports whether challenges are signed instead of stored.
+func (s *Signer) Enabled() bool {
+	return s.enabled
+}
+
+// Sign adds the signature of the stamp to its Ext field.
+func (s *Signer) Sign(stamp *Hashcash) {
+	mac := s.mac(s.keys[s.activeKey], stamp)
+	stamp.Ext = joinExt(stamp.Ext, formatExt(extMAC, s.activeKey+macKeySep+mac))
+}
+
+// Authenticate checks that the stamp has been signed with one of the known keys
+// and none of its fields except the counter has been changed since.
+func (s *Signer) Authenticate(stamp *Hashcash) error {
+	keyID, mac, ok := strings.Cut(extValue(stamp.Ext, extMAC), macKeySep)
+	if !ok {
+		return fmt.Errorf("%w: no signature", ErrInvalidSignature)
+	}
+
+	key, ok := s.keys[keyID]
+	if !ok {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidSignature, keyID)
+	}
+
+	if !hmac.Equal([]byte(mac), []byte(s.mac(key, stamp))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
+
+// mac signs version, bits, date, resource, rand and every Ext entry except the signature itself.
+func (s *Signer) mac(key []byte, stamp *Hashcash) string {
+	msg := fmt.Sprintf("%d:%d:%d:%s:%s:%d",
+		stamp.Version,
+		stamp.Bits,
+		stamp.Date.Unix(),
+		stamp.Resource,
+		withoutExt(stamp.Ext, extMAC),
+		stamp.Rand,
+	)
+
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(msg))
+
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
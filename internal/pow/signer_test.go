@@ -0,0 +1,119 @@
+package pow
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSigner(t *testing.T, activeKey string, keys map[string]string) *Signer {
+	t.Helper()
+
+	cfg := config.Config{}
+	cfg.Pow.Stateless = config.Stateless{Enabled: true, ActiveKey: activeKey, Keys: keys}
+
+	signer, err := NewSigner(cfg)
+	require.NoError(t, err)
+
+	return signer
+}
+
+func TestSignAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	signer := newTestSigner(t, "k1", map[string]string{"k1": "secret"})
+
+	hc, _ := NewHashcash("test", testBits)
+	signer.Sign(hc)
+	assert.NotEmpty(t, extValue(hc.Ext, extMAC))
+
+	parsed, err := NewHashcashFromString(hc.ToString())
+	require.NoError(t, err)
+	assert.NoError(t, signer.Authenticate(parsed))
+
+	// the counter is not signed, a solution stays authentic
+	require.NoError(t, parsed.SolveChallenge())
+	assert.NoError(t, signer.Authenticate(parsed))
+}
+
+func TestAuthenticateTampered(t *testing.T) {
+	t.Parallel()
+
+	signer := newTestSigner(t, "k1", map[string]string{"k1": "secret"})
+
+	tests := []struct {
+		name   string
+		tamper func(hc *Hashcash)
+	}{
+		{name: "version", tamper: func(hc *Hashcash) { hc.Version++ }},
+		{name: "bits", tamper: func(hc *Hashcash) { hc.Bits-- }},
+		{name: "date", tamper: func(hc *Hashcash) { hc.Date = hc.Date.Add(time.Hour) }},
+		{name: "resource", tamper: func(hc *Hashcash) { hc.Resource = "other" }},
+		{name: "rand", tamper: func(hc *Hashcash) { hc.Rand++ }},
+		{name: "algorithm", tamper: func(hc *Hashcash) { hc.Ext = joinExt(hc.Ext, "alg=scrypt") }},
+		{name: "unsigned", tamper: func(hc *Hashcash) { hc.Ext = "" }},
+		{name: "unknown key", tamper: func(hc *Hashcash) { hc.Ext = strings.Replace(hc.Ext, "k1.", "k9.", 1) }},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			hc, _ := NewHashcash("test", testBits)
+			signer.Sign(hc)
+			tcCopy.tamper(hc)
+
+			assert.ErrorIs(t, signer.Authenticate(hc), ErrInvalidSignature)
+		})
+	}
+}
+
+func TestSignerKeyRotation(t *testing.T) {
+	t.Parallel()
+
+	oldSigner := newTestSigner(t, "k1", map[string]string{"k1": "old secret"})
+	newSigner := newTestSigner(t, "k2", map[string]string{"k1": "old secret", "k2": "new secret"})
+
+	hc, _ := NewHashcash("test", testBits)
+	oldSigner.Sign(hc)
+
+	// challenges signed before the rotation are still accepted
+	assert.NoError(t, newSigner.Authenticate(hc))
+
+	hc, _ = NewHashcash("test", testBits)
+	newSigner.Sign(hc)
+	assert.Equal(t, "k2", strings.Split(extValue(hc.Ext, extMAC), macKeySep)[0])
+	assert.ErrorIs(t, oldSigner.Authenticate(hc), ErrInvalidSignature)
+}
+
+func TestNewSignerInvalidKeys(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		activeKey string
+		keys      map[string]string
+	}{
+		{name: "missing active key", activeKey: "k2", keys: map[string]string{"k1": "secret"}},
+		{name: "empty secret", activeKey: "k1", keys: map[string]string{"k1": ""}},
+		{name: "separator in id", activeKey: "k.1", keys: map[string]string{"k.1": "secret"}},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfg := config.Config{}
+			cfg.Pow.Stateless = config.Stateless{Enabled: true, ActiveKey: tcCopy.activeKey, Keys: tcCopy.keys}
+
+			_, err := NewSigner(cfg)
+			assert.ErrorIs(t, err, ErrInvalidSignerKey)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package pow
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"zenquote/internal/config"
+)
+
+var (
+	ErrChallengeExpired     = errors.New("challenge expired")
+	ErrChallengeNotYetValid = errors.New("challenge date is in the future")
+	ErrInvalidMaxAge        = errors.New("invalid max challenge age")
+)
+
+// Verifier checks solutions against issued challenges. A challenge is valid for MaxAge
+// after its date, give or take ClockSkew between the issuing and the verifying server.
+type Verifier struct {
+	maxAge    time.Duration
+	clockSkew time.Duration
+	now       func() time.Time
+}
+
+func NewVerifier(cfg config.Config) (*Verifier, error) {
+	if cfg.Pow.MaxAge <= 0 || cfg.Pow.ClockSkew < 0 {
+		return nil, fmt.Errorf("%w: max age %s, clock skew %s", ErrInvalidMaxAge, cfg.Pow.MaxAge, cfg.Pow.ClockSkew)
+	}
+
+	return &Verifier{maxAge: cfg.Pow.MaxAge, clockSkew: cfg.Pow.ClockSkew, now: time.Now}, nil
+}
+
+// Lifetime is how long an issued challenge has to be kept: its max age plus the clock skew.
+func (v *Verifier) Lifetime() time.Duration {
+	return v.maxAge + v.clockSkew
+}
+
+// ValidFor returns how long from now the stamp stays valid: until its date plus the max age and
+// the clock skew. A stamp dated ahead within the clock skew stays valid for longer than Lifetime.
+func (v *Verifier) ValidFor(stamp *Hashcash) time.Duration {
+	validFor := stamp.Date.Add(v.Lifetime()).Sub(v.now())
+	if validFor < time.Second {
+		return time.Second // records kept for the stamp must still expire
+	}
+
+	return validFor
+}
+
+// Verify checks that solution solves the issued puzzle for the given resource.
+// Every stamp field except the counter must be equal to the issued one, so a client can not
+// forge its own challenge, and the challenge must not be older than the max age.
+// Returns ErrWrongResource, ErrChallengeMismatch, ErrChallengeExpired, ErrChallengeNotYetValid
+// or ErrInsufficientWork.
+func (v *Verifier) Verify(solution Puzzle, issued Puzzle, resource string) error {
+	if err := solution.Stamp().matches(issued.Stamp(), resource); err != nil {
+		return err
+	}
+
+	if err := v.checkAge(issued.Stamp()); err != nil {
+		return err
+	}
+
+	if !solution.ValidateSolution() {
+		return ErrInsufficientWork
+	}
+
+	return nil
+}
+
+func (v *Verifier) checkAge(stamp *Hashcash) error {
+	age := v.now().Sub(stamp.Date)
+
+	if age > v.maxAge+v.clockSkew {
+		return fmt.Errorf("%w: issued %s ago", ErrChallengeExpired, age.Truncate(time.Second))
+	}
+
+	if age < -v.clockSkew {
+		return fmt.Errorf("%w: issued in %s", ErrChallengeNotYetValid, (-age).Truncate(time.Second))
+	}
+
+	return nil
+}
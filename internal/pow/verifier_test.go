@@ -0,0 +1,85 @@
+package pow
+
+import (
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testVerifier = &Verifier{maxAge: time.Hour, clockSkew: time.Minute, now: time.Now}
+
+func TestNewVerifier(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.Pow.MaxAge = 5 * time.Minute
+	cfg.Pow.ClockSkew = 30 * time.Second
+
+	verifier, err := NewVerifier(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute+30*time.Second, verifier.Lifetime())
+
+	cfg.Pow.MaxAge = 0
+	_, err = NewVerifier(cfg)
+	assert.ErrorIs(t, err, ErrInvalidMaxAge)
+}
+
+func TestVerifyAge(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1625075186, 0)
+	verifier := &Verifier{maxAge: 5 * time.Minute, clockSkew: 30 * time.Second, now: func() time.Time { return now }}
+
+	tests := []struct {
+		name    string
+		date    time.Time
+		wantErr error
+	}{
+		{name: "fresh", date: now, wantErr: nil},
+		{name: "within max age", date: now.Add(-5 * time.Minute), wantErr: nil},
+		{name: "within clock skew", date: now.Add(-5*time.Minute - 30*time.Second), wantErr: nil},
+		{name: "expired", date: now.Add(-5*time.Minute - 31*time.Second), wantErr: ErrChallengeExpired},
+		{name: "slightly ahead", date: now.Add(30 * time.Second), wantErr: nil},
+		{name: "from the future", date: now.Add(31 * time.Second), wantErr: ErrChallengeNotYetValid},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			hc, _ := NewHashcash("test", testBits)
+			hc.Date = tcCopy.date
+			require.NoError(t, hc.SolveChallenge())
+
+			err := verifier.Verify(hc, hc, "test")
+			if tcCopy.wantErr == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tcCopy.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidFor(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1625075186, 0)
+	verifier := &Verifier{maxAge: 5 * time.Minute, clockSkew: 30 * time.Second, now: func() time.Time { return now }}
+
+	hc, _ := NewHashcash("test", testBits)
+
+	hc.Date = now
+	assert.Equal(t, 5*time.Minute+30*time.Second, verifier.ValidFor(hc))
+
+	// a stamp dated ahead is valid until its own date plus the lifetime
+	hc.Date = now.Add(30 * time.Second)
+	assert.Equal(t, 6*time.Minute, verifier.ValidFor(hc))
+
+	hc.Date = now.Add(-time.Hour)
+	assert.Equal(t, time.Second, verifier.ValidFor(hc))
+}
@@ -0,0 +1,249 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"zenquote/internal/config"
+)
+
+const (
+	v1Prefix    = "PROXY "
+	v1MaxLength = 107 // longest v1 header, with the CRLF
+	v2Signature = "\r\n\r\n\x00\r\nQUIT\n"
+	v2HeaderLen = 16 // signature, version and command, family and protocol, address length
+
+	v2Version = 0x20
+	v2Local   = 0x00 // health checks of the proxy itself, the connection address is kept
+	v2Proxy   = 0x01
+
+	v2TCP4 = 0x11
+	v2TCP6 = 0x21
+)
+
+var (
+	ErrInvalidConfig = errors.New("invalid proxy protocol config")
+	ErrInvalidHeader = errors.New("invalid proxy protocol header")
+)
+
+// Policy decides which connections start with a PROXY protocol header: those from trusted proxies.
+// Connections from anywhere else are taken as they are, so clients can not spoof their address.
+type Policy struct {
+	enabled bool
+	trusted []*net.IPNet
+}
+
+func NewPolicy(cfg config.Config) (*Policy, error) {
+	pcfg := cfg.TCP.ProxyProtocol
+
+	policy := &Policy{enabled: pcfg.Enabled, trusted: nil}
+	if !pcfg.Enabled {
+		return policy, nil
+	}
+
+	if len(pcfg.TrustedCIDRs) == 0 {
+		return nil, fmt.Errorf("%w: trusted proxy CIDRs are required", ErrInvalidConfig)
+	}
+
+	for _, cidr := range pcfg.TrustedCIDRs {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+
+		policy.trusted = append(policy.trusted, network)
+	}
+
+	return policy, nil
+}
+
+// Enabled reports whether connections from trusted proxies carry a header.
+func (p *Policy) Enabled() bool {
+	return p.enabled
+}
+
+// Trusts reports whether the address is one of a trusted proxy.
+func (p *Policy) Trusts(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, network := range p.trusted {
+		if network.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Listen returns a listener reading the header of the connections from trusted proxies.
+func (p *Policy) Listen(listener net.Listener) net.Listener {
+	return &Listener{Listener: listener, policy: p}
+}
+
+type Listener struct {
+	net.Listener
+	policy *Policy
+}
+
+// Accept does not read the header, so a slow proxy does not hold up the accept loop.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.policy.Trusts(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	return NewConn(conn), nil
+}
+
+// Conn reads the PROXY protocol header on its first use and reports the client address of the
+// header as its remote address. A header without a client address keeps the connection address.
+type Conn struct {
+	net.Conn
+	reader *bufio.Reader
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{Conn: conn, reader: bufio.NewReader(conn), once: sync.Once{}, remote: nil, err: nil}
+}
+
+// Read reads the data following the header, an invalid header is an ErrInvalidHeader error.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address of the header, it waits for the header to be read.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+
+	if c.remote != nil {
+		return c.remote
+	}
+
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeader() {
+	c.remote, c.err = ReadHeader(c.reader)
+}
+
+// ReadHeader reads a v1 or v2 header and returns the client address, nil if it has none.
+func ReadHeader(reader *bufio.Reader) (net.Addr, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return nil, fmt.Errorf("read header failed: %w", err)
+	}
+
+	switch first[0] {
+	case v1Prefix[0]:
+		return readV1(reader)
+	case v2Signature[0]:
+		return readV2(reader)
+	default:
+		return nil, fmt.Errorf("%w: no header", ErrInvalidHeader)
+	}
+}
+
+// readV1 reads a header like "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func readV1(reader *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, v1MaxLength)
+
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == v1MaxLength {
+			return nil, fmt.Errorf("%w: v1 header too long", ErrInvalidHeader)
+		}
+
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("read header failed: %w", err)
+		}
+
+		line = append(line, b)
+	}
+
+	fields := strings.Split(strings.TrimSuffix(string(line), "\r\n"), " ")
+	if len(fields) < 2 || fields[0] != strings.TrimSpace(v1Prefix) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidHeader, line)
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || net.ParseIP(fields[3]) == nil || (ip.To4() != nil) != (fields[1] == "TCP4") {
+		return nil, fmt.Errorf("%w: bad address in %q", ErrInvalidHeader, line)
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad port in %q", ErrInvalidHeader, line)
+	}
+
+	if _, err = strconv.ParseUint(fields[5], 10, 16); err != nil {
+		return nil, fmt.Errorf("%w: bad port in %q", ErrInvalidHeader, line)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readV2 reads a binary header, its TLVs are skipped.
+func readV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("read header failed: %w", err)
+	}
+
+	if string(header[:len(v2Signature)]) != v2Signature || header[12]&0xF0 != v2Version {
+		return nil, fmt.Errorf("%w: bad v2 signature or version", ErrInvalidHeader)
+	}
+
+	command, family := header[12]&0x0F, header[13]
+
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(reader, addresses); err != nil {
+		return nil, fmt.Errorf("read header failed: %w", err)
+	}
+
+	switch {
+	case command == v2Local:
+		return nil, nil
+	case command != v2Proxy:
+		return nil, fmt.Errorf("%w: unknown v2 command %d", ErrInvalidHeader, command)
+	case family == v2TCP4 && len(addresses) >= 12:
+		return &net.TCPAddr{IP: net.IP(addresses[:4]), Port: int(binary.BigEndian.Uint16(addresses[8:]))}, nil
+	case family == v2TCP6 && len(addresses) >= 36:
+		return &net.TCPAddr{IP: net.IP(addresses[:16]), Port: int(binary.BigEndian.Uint16(addresses[32:]))}, nil
+	case family == v2TCP4 || family == v2TCP6:
+		return nil, fmt.Errorf("%w: v2 addresses too short", ErrInvalidHeader)
+	default:
+		// UDP, unix sockets and unspecified have no client IP to take
+		return nil, nil
+	}
+}
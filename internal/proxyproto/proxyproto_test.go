@@ -0,0 +1,254 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const payload = "payload"
+
+func v2Header(verCmd byte, family byte, addresses []byte) []byte {
+	header := append([]byte(v2Signature), verCmd, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addresses)))
+
+	return append(header, addresses...)
+}
+
+func v2Addresses(src net.IP, dst net.IP, tlvs []byte) []byte {
+	addresses := append(append([]byte{}, src...), dst...)
+	addresses = binary.BigEndian.AppendUint16(addresses, 56324)
+	addresses = binary.BigEndian.AppendUint16(addresses, 443)
+
+	return append(addresses, tlvs...)
+}
+
+func TestReadHeader(t *testing.T) {
+	t.Parallel()
+
+	ipv4 := net.ParseIP("192.0.2.1").To4()
+	ipv6 := net.ParseIP("2001:db8::1")
+	tlv := []byte{0x04, 0x00, 0x01, 0xAA} // noop TLV
+
+	tests := []struct {
+		name     string
+		header   []byte
+		expected string // client address, empty if the header has none
+		invalid  bool
+	}{
+		{
+			name:     "v1 tcp4",
+			header:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n"),
+			expected: "192.0.2.1:56324",
+			invalid:  false,
+		},
+		{
+			name:     "v1 tcp6",
+			header:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			expected: "[2001:db8::1]:56324",
+			invalid:  false,
+		},
+		{
+			name:     "v1 unknown",
+			header:   []byte("PROXY UNKNOWN\r\n"),
+			expected: "",
+			invalid:  false,
+		},
+		{
+			name:     "v1 family mismatch",
+			header:   []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			expected: "",
+			invalid:  true,
+		},
+		{
+			name:     "v1 bad port",
+			header:   []byte("PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n"),
+			expected: "",
+			invalid:  true,
+		},
+		{
+			name:     "v1 too long",
+			header:   []byte("PROXY TCP4 " + strings.Repeat("1", v1MaxLength) + "\r\n"),
+			expected: "",
+			invalid:  true,
+		},
+		{
+			name:     "v2 tcp4 with tlv",
+			header:   v2Header(v2Version|v2Proxy, v2TCP4, v2Addresses(ipv4, net.ParseIP("198.51.100.1").To4(), tlv)),
+			expected: "192.0.2.1:56324",
+			invalid:  false,
+		},
+		{
+			name:     "v2 tcp6",
+			header:   v2Header(v2Version|v2Proxy, v2TCP6, v2Addresses(ipv6, net.ParseIP("2001:db8::2"), nil)),
+			expected: "[2001:db8::1]:56324",
+			invalid:  false,
+		},
+		{
+			name:     "v2 local",
+			header:   v2Header(v2Version|v2Local, 0, nil),
+			expected: "",
+			invalid:  false,
+		},
+		{
+			name:     "v2 short addresses",
+			header:   v2Header(v2Version|v2Proxy, v2TCP6, v2Addresses(ipv4, ipv4, nil)),
+			expected: "",
+			invalid:  true,
+		},
+		{
+			name:     "v2 bad version",
+			header:   v2Header(0x10|v2Proxy, v2TCP4, v2Addresses(ipv4, ipv4, nil)),
+			expected: "",
+			invalid:  true,
+		},
+		{
+			name:     "no header",
+			header:   []byte("hello\n"),
+			expected: "",
+			invalid:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			reader := bufio.NewReader(bytes.NewReader(append(tcCopy.header, payload...)))
+
+			addr, err := ReadHeader(reader)
+			if tcCopy.invalid {
+				assert.ErrorIs(t, err, ErrInvalidHeader)
+
+				return
+			}
+
+			require.NoError(t, err)
+
+			if tcCopy.expected == "" {
+				assert.Nil(t, addr)
+			} else {
+				assert.Equal(t, tcCopy.expected, addr.String())
+			}
+
+			// the data after the header is left to read
+			rest, err := io.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, payload, string(rest))
+		})
+	}
+}
+
+func TestConn(t *testing.T) {
+	t.Parallel()
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	go func() {
+		_, _ = clientConn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n" + payload))
+	}()
+
+	conn := NewConn(serverConn)
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+
+	data := make([]byte, len(payload))
+	_, err := io.ReadFull(conn, data)
+	require.NoError(t, err)
+	assert.Equal(t, payload, string(data))
+}
+
+func TestConnInvalidHeader(t *testing.T) {
+	t.Parallel()
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	go func() {
+		_, _ = clientConn.Write([]byte("hello\n"))
+	}()
+
+	conn := NewConn(serverConn)
+
+	_, err := conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	// the connection address is kept
+	assert.Equal(t, serverConn.RemoteAddr(), conn.RemoteAddr())
+}
+
+func TestPolicy(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.TCP.ProxyProtocol = config.ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"10.0.0.0/8", "2001:db8::/32"}}
+
+	policy, err := NewPolicy(cfg)
+	require.NoError(t, err)
+	assert.True(t, policy.Enabled())
+
+	assert.True(t, policy.Trusts(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 1}))
+	assert.True(t, policy.Trusts(&net.TCPAddr{IP: net.ParseIP("2001:db8::5"), Port: 1}))
+	assert.False(t, policy.Trusts(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}))
+	assert.False(t, policy.Trusts(&net.UnixAddr{Name: "socket", Net: "unix"}))
+
+	cfg.TCP.ProxyProtocol.TrustedCIDRs = []string{"10.0.0.0/33"}
+
+	_, err = NewPolicy(cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	cfg.TCP.ProxyProtocol.TrustedCIDRs = nil
+
+	_, err = NewPolicy(cfg)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
+
+func TestListener(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.TCP.ProxyProtocol = config.ProxyProtocol{Enabled: true, TrustedCIDRs: []string{"127.0.0.0/8"}}
+
+	policy, err := NewPolicy(cfg)
+	require.NoError(t, err)
+
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	listener := policy.Listen(inner)
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	go func() {
+		conn, err := net.Dial("tcp", inner.Addr().String())
+		if err != nil {
+			return
+		}
+
+		_, _ = conn.Write([]byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"))
+		_ = conn.Close()
+	}()
+
+	conn, err := listener.Accept()
+	require.NoError(t, err)
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	assert.Equal(t, "[2001:db8::1]:56324", conn.RemoteAddr().String())
+}
@@ -0,0 +1,78 @@
+package quote
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+var ErrNoMatch = errors.New("no quote matches the filter")
+
+// Filter selects quotes. Empty fields match any quote.
+type Filter struct {
+	Author   string // author name, case insensitive
+	Tag      string // one of the quote tags, case insensitive
+	Keyword  string // searched in the text, case insensitive
+	Language string // BCP 47 tag, a language without region also matches its regional variants
+	Daily    bool   // the same quote among the matching ones for the whole UTC day
+}
+
+// Selector is a quote source able to serve quotes matching a filter.
+// Select returns ErrNoMatch if it has no matching quote.
+type Selector interface {
+	Select(ctx context.Context, filter Filter) (Quote, error)
+}
+
+// IsZero reports whether the filter matches any quote and does not ask for the daily one.
+func (f Filter) IsZero() bool {
+	return f == Filter{}
+}
+
+// Matches reports whether the quote matches every field of the filter.
+func (f Filter) Matches(q Quote) bool {
+	if f.Author != "" && !strings.EqualFold(f.Author, q.Author) {
+		return false
+	}
+
+	if f.Tag != "" && !hasTag(q.Tags, f.Tag) {
+		return false
+	}
+
+	if f.Keyword != "" && !strings.Contains(strings.ToLower(q.Text), strings.ToLower(f.Keyword)) {
+		return false
+	}
+
+	if f.Language != "" && !matchesLanguage(q.Language, f.Language) {
+		return false
+	}
+
+	return true
+}
+
+// DailyIndex returns an index in [0, n) that stays the same for the whole UTC day of t.
+func DailyIndex(t time.Time, n int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(t.UTC().Format(time.DateOnly)))
+
+	return int(hash.Sum32() % uint32(n))
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesLanguage(language string, wanted string) bool {
+	if strings.EqualFold(language, wanted) {
+		return true
+	}
+
+	return len(language) > len(wanted) && language[len(wanted)] == '-' && strings.EqualFold(language[:len(wanted)], wanted)
+}
@@ -0,0 +1,67 @@
+package quote_test
+
+import (
+	"testing"
+	"time"
+	"zenquote/internal/quote"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterMatches(t *testing.T) {
+	t.Parallel()
+
+	q := quote.Quote{
+		ID:       "q1",
+		Text:     "Nature does not hurry, yet everything is accomplished.",
+		Author:   "Lao Tzu",
+		Source:   "",
+		Tags:     []string{"patience", "nature"},
+		Language: "en-US",
+	}
+
+	tests := []struct {
+		name     string
+		filter   quote.Filter
+		expected bool
+	}{
+		{name: "empty", filter: quote.Filter{Author: "", Tag: "", Keyword: "", Language: "", Daily: false}, expected: true},
+		{name: "author", filter: quote.Filter{Author: "LAO TZU", Tag: "", Keyword: "", Language: "", Daily: false}, expected: true},
+		{name: "other author", filter: quote.Filter{Author: "Lao", Tag: "", Keyword: "", Language: "", Daily: false}, expected: false},
+		{name: "tag", filter: quote.Filter{Author: "", Tag: "Nature", Keyword: "", Language: "", Daily: false}, expected: true},
+		{name: "other tag", filter: quote.Filter{Author: "", Tag: "love", Keyword: "", Language: "", Daily: false}, expected: false},
+		{name: "keyword", filter: quote.Filter{Author: "", Tag: "", Keyword: "HURRY", Language: "", Daily: false}, expected: true},
+		{name: "language", filter: quote.Filter{Author: "", Tag: "", Keyword: "", Language: "en", Daily: false}, expected: true},
+		{name: "region", filter: quote.Filter{Author: "", Tag: "", Keyword: "", Language: "en-us", Daily: false}, expected: true},
+		{name: "prefix", filter: quote.Filter{Author: "", Tag: "", Keyword: "", Language: "e", Daily: false}, expected: false},
+		{name: "all", filter: quote.Filter{Author: "Lao Tzu", Tag: "patience", Keyword: "nature", Language: "en", Daily: true}, expected: true},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tcCopy.expected, tcCopy.filter.Matches(q))
+		})
+	}
+}
+
+func TestDailyIndex(t *testing.T) {
+	t.Parallel()
+
+	morning := time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC)
+	evening := time.Date(2024, 5, 1, 23, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, quote.DailyIndex(morning, 100), quote.DailyIndex(evening, 100))
+
+	days := make(map[int]bool)
+	for day := 0; day < 30; day++ {
+		index := quote.DailyIndex(morning.AddDate(0, 0, day), 100)
+		assert.True(t, index >= 0 && index < 100)
+
+		days[index] = true
+	}
+
+	assert.Greater(t, len(days), 1)
+}
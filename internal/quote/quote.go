@@ -0,0 +1,26 @@
+package quote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const idBytes = 8
+
+// Quote is a quote with the attribution required to display it.
+type Quote struct {
+	ID       string   // stable id of the quote within its source
+	Text     string   // quote text
+	Author   string   // quote author, empty if unknown
+	Source   string   // where the quote comes from, displayed as attribution
+	Tags     []string // topics of the quote
+	Language string   // BCP 47 language tag of the text
+}
+
+// NewID returns a stable id derived from the author and the text,
+// for sources that do not identify their quotes.
+func NewID(author string, text string) string {
+	sum := sha256.Sum256([]byte(author + "\x00" + text))
+
+	return hex.EncodeToString(sum[:idBytes])
+}
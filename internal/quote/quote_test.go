@@ -0,0 +1,19 @@
+package quote_test
+
+import (
+	"testing"
+	"zenquote/internal/quote"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewID(t *testing.T) {
+	t.Parallel()
+
+	id := quote.NewID("Lao Tzu", "Nature does not hurry, yet everything is accomplished.")
+
+	assert.Len(t, id, 16)
+	assert.Equal(t, id, quote.NewID("Lao Tzu", "Nature does not hurry, yet everything is accomplished."))
+	assert.NotEqual(t, id, quote.NewID("Unknown", "Nature does not hurry, yet everything is accomplished."))
+	assert.NotEqual(t, quote.NewID("a", "bc"), quote.NewID("ab", "c"))
+}
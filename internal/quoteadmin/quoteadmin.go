@@ -0,0 +1,175 @@
+package quoteadmin
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"zenquote/internal/quote"
+	"zenquote/internal/quotestore"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var (
+	ErrUnknownID = errors.New("unknown quote id")
+	ErrNoText    = errors.New("quote has no text")
+)
+
+// Report counts the outcome of an import.
+type Report struct {
+	Added      int // new quotes
+	Duplicates int // quotes already in the collection, by normalized text or id
+	Invalid    int // quotes without text
+}
+
+// Normalize returns the record with its text in NFC with single spaces, trimmed fields,
+// lower case unique tags and the id derived from the author and the text if empty.
+func Normalize(r quotestore.Record) quotestore.Record {
+	r.Text = normalizeText(r.Text)
+	r.Author = normalizeText(r.Author)
+	r.Source = normalizeText(r.Source)
+	r.Language = strings.TrimSpace(r.Language)
+
+	tags := make([]string, 0, len(r.Tags))
+	seen := make(map[string]bool, len(r.Tags))
+
+	for _, tag := range r.Tags {
+		tag = strings.ToLower(normalizeText(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	r.Tags = nil
+	if len(tags) > 0 {
+		r.Tags = tags
+	}
+
+	r.ID = strings.TrimSpace(r.ID)
+	if r.ID == "" && r.Text != "" {
+		r.ID = quote.NewID(r.Author, r.Text)
+	}
+
+	return r
+}
+
+// Key returns the text compared to find duplicates: compatibility normalized, case folded,
+// with the punctuation and the spaces dropped, so the same quote typed differently matches.
+func Key(text string) string {
+	var key strings.Builder
+
+	for _, r := range strings.ToLower(norm.NFKC.String(text)) {
+		if isWordRune(r) {
+			key.WriteRune(r)
+		}
+	}
+
+	return key.String()
+}
+
+// Import normalizes the incoming records and appends the new ones to the collection.
+// The collection itself is expected to be deduplicated already, see Dedupe.
+func Import(collection []quotestore.Record, incoming []quotestore.Record) ([]quotestore.Record, Report) {
+	var report Report
+
+	index := newIndex(collection)
+
+	for _, r := range incoming {
+		r = Normalize(r)
+
+		switch {
+		case r.Text == "":
+			report.Invalid++
+		case index.contains(r):
+			report.Duplicates++
+		default:
+			index.add(r)
+			collection = append(collection, r)
+			report.Added++
+		}
+	}
+
+	return collection, report
+}
+
+// Dedupe normalizes the collection and drops the records duplicating an earlier one.
+// It returns the number of dropped records, records without text are an error.
+func Dedupe(collection []quotestore.Record) ([]quotestore.Record, int, error) {
+	index := newIndex(nil)
+	deduped := make([]quotestore.Record, 0, len(collection))
+
+	for i, r := range collection {
+		r = Normalize(r)
+
+		if r.Text == "" {
+			return nil, 0, fmt.Errorf("%w: record %d", ErrNoText, i)
+		}
+
+		if index.contains(r) {
+			continue
+		}
+
+		index.add(r)
+		deduped = append(deduped, r)
+	}
+
+	return deduped, len(collection) - len(deduped), nil
+}
+
+// SetDisabled disables or enables the quotes with the given ids, as served to clients.
+// Nothing is changed if an id is unknown.
+func SetDisabled(collection []quotestore.Record, ids []string, disabled bool) error {
+	positions := make(map[string]int, len(collection))
+	for i, r := range collection {
+		positions[r.QuoteID()] = i
+	}
+
+	for _, id := range ids {
+		if _, ok := positions[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownID, id)
+		}
+	}
+
+	for _, id := range ids {
+		collection[positions[id]].Disabled = disabled
+	}
+
+	return nil
+}
+
+// index finds the records of a collection by id and by normalized text.
+type index struct {
+	ids  map[string]bool
+	keys map[string]bool
+}
+
+func newIndex(collection []quotestore.Record) *index {
+	idx := &index{ids: make(map[string]bool, len(collection)), keys: make(map[string]bool, len(collection))}
+	for _, r := range collection {
+		idx.add(r)
+	}
+
+	return idx
+}
+
+func (idx *index) add(r quotestore.Record) {
+	idx.ids[r.QuoteID()] = true
+	idx.keys[Key(r.Text)] = true
+}
+
+func (idx *index) contains(r quotestore.Record) bool {
+	return idx.ids[r.QuoteID()] || idx.keys[Key(r.Text)]
+}
+
+// normalizeText composes the text to NFC and collapses the whitespace, including line breaks, to single spaces.
+func normalizeText(text string) string {
+	return strings.Join(strings.Fields(norm.NFC.String(text)), " ")
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
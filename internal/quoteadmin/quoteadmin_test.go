@@ -0,0 +1,91 @@
+package quoteadmin_test
+
+import (
+	"testing"
+	"zenquote/internal/quote"
+	"zenquote/internal/quoteadmin"
+	"zenquote/internal/quotestore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func record(id string, text string, author string, tags ...string) quotestore.Record {
+	return quotestore.Record{ID: id, Text: text, Author: author, Source: "", Tags: tags, Language: "", Disabled: false}
+}
+
+func TestNormalize(t *testing.T) {
+	t.Parallel()
+
+	// "e" followed by a combining acute accent is composed to a single rune
+	r := quoteadmin.Normalize(record("", " Cafe\u0301 \n  au   lait. ", "  Anonymous ", "Food", " food ", "", "Drink"))
+
+	assert.Equal(t, "Caf\u00e9 au lait.", r.Text)
+	assert.Equal(t, "Anonymous", r.Author)
+	assert.Equal(t, []string{"food", "drink"}, r.Tags)
+	assert.Equal(t, quote.NewID("Anonymous", "Caf\u00e9 au lait."), r.ID)
+}
+
+func TestKey(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, quoteadmin.Key("Be here now."), quoteadmin.Key("be  here, NOW!"))
+	assert.Equal(t, quoteadmin.Key("Caf\u00e9"), quoteadmin.Key("Cafe\u0301"))
+	assert.Equal(t, quoteadmin.Key("\ufb01ne"), quoteadmin.Key("fine")) // the fi ligature
+	assert.NotEqual(t, quoteadmin.Key("Be here now."), quoteadmin.Key("Be there now."))
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	collection := []quotestore.Record{record("q1", "Be here now.", "Ram Dass")}
+
+	collection, report := quoteadmin.Import(collection, []quotestore.Record{
+		record("", "be here,  now!", "Someone"),
+		record("q1", "A different quote with a taken id.", ""),
+		record("", "   ", "Nobody"),
+		record("", "Breathe.", ""),
+		record("", "Breathe.", ""),
+	})
+
+	assert.Equal(t, quoteadmin.Report{Added: 1, Duplicates: 3, Invalid: 1}, report)
+	require.Len(t, collection, 2)
+	assert.Equal(t, "Breathe.", collection[1].Text)
+	assert.NotEmpty(t, collection[1].ID)
+}
+
+func TestDedupe(t *testing.T) {
+	t.Parallel()
+
+	deduped, dropped, err := quoteadmin.Dedupe([]quotestore.Record{
+		record("", "Be here now.", "Ram Dass"),
+		record("", "Breathe.", ""),
+		record("", "BE HERE NOW", "Ram Dass"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, dropped)
+	assert.Len(t, deduped, 2)
+
+	_, _, err = quoteadmin.Dedupe([]quotestore.Record{record("", " ", "")})
+	assert.ErrorIs(t, err, quoteadmin.ErrNoText)
+}
+
+func TestSetDisabled(t *testing.T) {
+	t.Parallel()
+
+	// records without an id are found by the id derived when they are served
+	collection := []quotestore.Record{record("q1", "Be here now.", "Ram Dass"), record("", "Breathe.", "")}
+	derived := quote.NewID("", "Breathe.")
+
+	require.NoError(t, quoteadmin.SetDisabled(collection, []string{"q1", derived}, true))
+	assert.True(t, collection[0].Disabled)
+	assert.True(t, collection[1].Disabled)
+
+	require.NoError(t, quoteadmin.SetDisabled(collection, []string{derived}, false))
+	assert.False(t, collection[1].Disabled)
+
+	// nothing changes if an id is unknown
+	err := quoteadmin.SetDisabled(collection, []string{derived, "missing"}, true)
+	assert.ErrorIs(t, err, quoteadmin.ErrUnknownID)
+	assert.False(t, collection[1].Disabled)
+}
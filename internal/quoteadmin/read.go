@@ -0,0 +1,98 @@
+package quoteadmin
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"zenquote/internal/quotestore"
+)
+
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+
+	tagSeparator = ";"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown import format")
+	ErrNoTextColumn  = errors.New("csv has no text column")
+)
+
+// Read reads quotes to import in the given format.
+func Read(r io.Reader, format string) ([]quotestore.Record, error) {
+	switch format {
+	case FormatCSV:
+		return ReadCSV(r)
+	case FormatJSON:
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("read quotes failed: %w", err)
+		}
+
+		return quotestore.ReadRecords(data)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+}
+
+// ReadCSV reads quotes from a CSV file with a header row. The text column is required,
+// the optional ones are id, author, source, tags separated by semicolons and language.
+// Other columns are ignored.
+func ReadCSV(r io.Reader) ([]quotestore.Record, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read csv header failed: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+
+	if _, ok := columns["text"]; !ok {
+		return nil, ErrNoTextColumn
+	}
+
+	var records []quotestore.Record
+
+	for {
+		row, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("read csv failed: %w", err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return row[i]
+			}
+
+			return ""
+		}
+
+		var tags []string
+		if value := field("tags"); value != "" {
+			tags = strings.Split(value, tagSeparator)
+		}
+
+		records = append(records, quotestore.Record{
+			ID:       field("id"),
+			Text:     field("text"),
+			Author:   field("author"),
+			Source:   field("source"),
+			Tags:     tags,
+			Language: field("language"),
+			Disabled: false,
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package quoteadmin_test
+
+import (
+	"strings"
+	"testing"
+	"zenquote/internal/quoteadmin"
+	"zenquote/internal/quotestore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRead(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		format   string
+		content  string
+		expected []quotestore.Record
+		wantErr  error
+	}{
+		{
+			name:   "csv",
+			format: quoteadmin.FormatCSV,
+			content: "Text,Author,Tags,Language,Notes\n" +
+				"\"Be here now.\",Ram Dass,mindfulness;presence,en,ignored\n" +
+				"Breathe.\n",
+			expected: []quotestore.Record{
+				{ID: "", Text: "Be here now.", Author: "Ram Dass", Source: "", Tags: []string{"mindfulness", "presence"}, Language: "en", Disabled: false},
+				{ID: "", Text: "Breathe.", Author: "", Source: "", Tags: nil, Language: "", Disabled: false},
+			},
+			wantErr: nil,
+		},
+		{name: "csv without text", format: quoteadmin.FormatCSV, content: "quote,author\n", expected: nil, wantErr: quoteadmin.ErrNoTextColumn},
+		{
+			name:    "json",
+			format:  quoteadmin.FormatJSON,
+			content: `[{"text": "Breathe.", "disabled": true}]`,
+			expected: []quotestore.Record{
+				{ID: "", Text: "Breathe.", Author: "", Source: "", Tags: nil, Language: "", Disabled: true},
+			},
+			wantErr: nil,
+		},
+		{name: "unknown format", format: "xml", content: "", expected: nil, wantErr: quoteadmin.ErrUnknownFormat},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			records, err := quoteadmin.Read(strings.NewReader(tcCopy.content), tcCopy.format)
+			if tcCopy.wantErr != nil {
+				assert.ErrorIs(t, err, tcCopy.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tcCopy.expected, records)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package quoteapi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"zenquote/internal/config"
+	"zenquote/internal/tlsconfig"
+)
+
+// NewHTTPClient returns the HTTP client for the quote API with the configured timeout, proxy and CA.
+func NewHTTPClient(cfg config.Config) (*http.Client, error) {
+	apiCfg := cfg.Quotes.API
+
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected default transport %T", http.DefaultTransport)
+	}
+
+	transport = transport.Clone()
+
+	if apiCfg.Proxy != "" {
+		proxyURL, err := url.Parse(apiCfg.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url failed: %w", err)
+		}
+
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if apiCfg.CAFile != "" {
+		// the CA file is trusted besides the system certificates
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if err = tlsconfig.AppendCertPool(pool, apiCfg.CAFile); err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+
+	return &http.Client{Transport: transport, Timeout: apiCfg.Timeout}, nil
+}
@@ -0,0 +1,58 @@
+package quoteapi_test
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"zenquote/internal/config"
+	"zenquote/internal/quoteapi"
+	"zenquote/internal/tlsconfig"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPClient(t *testing.T) {
+	t.Parallel()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`[{"q": "Trusted", "a": "Unknown"}]`))
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Headers: nil, Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, caPEM, 0o600))
+
+	cfg := testConfig(server)
+	cfg.Quotes.API.CAFile = caFile
+
+	httpClient, err := quoteapi.NewHTTPClient(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, cfg.Quotes.API.Timeout, httpClient.Timeout)
+
+	quoteAPI, err := quoteapi.NewQuoteAPI(cfg, httpClient)
+	require.NoError(t, err)
+
+	quote, err := quoteAPI.GetRandom(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Trusted", quote.Text)
+}
+
+func TestNewHTTPClientInvalidCA(t *testing.T) {
+	t.Parallel()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, []byte("not a certificate"), 0o600))
+
+	var cfg config.Config
+	cfg.Quotes.API.CAFile = caFile
+
+	_, err := quoteapi.NewHTTPClient(cfg)
+	assert.ErrorIs(t, err, tlsconfig.ErrInvalidCA)
+}
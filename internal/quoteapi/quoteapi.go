@@ -7,61 +7,308 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
 )
 
 const (
-	apiURL        = "http://zenquotes.io"
-	apiRandomPath = "/api/random"
+	apiURL          = "https://zenquotes.io"
+	apiRandomPath   = "/api/random"
+	apiQuotesPath   = "/api/quotes"                           // a batch of random quotes
+	apiSource       = "ZenQuotes API (https://zenquotes.io/)" // attribution required by the ZenQuotes terms
+	apiLanguage     = "en"
+	apiNoticeAuthor = "zenquotes.io" // author of the notices sent in place of quotes
+	redactedKey     = "REDACTED"     // logged in place of the API key
+
+	defaultRetryAfter = 30 * time.Second // hold after a rate limit without Retry-After, the ZenQuotes window
+)
+
+var (
+	ErrEmptyQuotes      = errors.New("received empty quotes")
+	ErrRateLimited      = errors.New("rate limited by the quote api")
+	ErrUnexpectedStatus = errors.New("unexpected response status")
+	ErrInvalidBaseURL   = errors.New("invalid quote api base url")
 )
 
-var ErrEmptyQuotes = errors.New("received empty quotes")
+// RateLimitError is returned when the API rejects a request over its rate limit.
+type RateLimitError struct {
+	RetryAfter time.Duration // delay requested by the API, 0 if unknown
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrRateLimited, e.RetryAfter)
+}
+
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
 
 type Quote struct {
 	Quote  string `json:"q"`
 	Author string `json:"a"`
 }
 
+// StatusError is returned when the API answers with an unexpected status.
+// It leaves out the request URL, the API key is part of it.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: %d %s", ErrUnexpectedStatus, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+func (e *StatusError) Unwrap() error {
+	return ErrUnexpectedStatus
+}
+
+// QuoteAPI is shared by the api provider and the pool. Once the API rate limits a request, no
+// request is sent until its Retry-After has passed, so neither of them runs into the limit again.
 type QuoteAPI struct {
+	cfg        config.QuoteAPI
 	httpClient *http.Client
+
+	mu           sync.Mutex
+	limitedUntil time.Time
 }
 
-func NewQuoteAPI(httpClient *http.Client) *QuoteAPI {
-	return &QuoteAPI{
-		httpClient: httpClient,
+// NewQuoteAPI returns the client of the configured API, ZenQuotes if no URL or path is configured.
+func NewQuoteAPI(cfg config.Config, httpClient *http.Client) (*QuoteAPI, error) {
+	apiCfg := cfg.Quotes.API
+	apiCfg.BaseURL = strings.TrimSuffix(withDefault(apiCfg.BaseURL, apiURL), "/")
+	apiCfg.RandomPath = withDefault(apiCfg.RandomPath, apiRandomPath)
+	apiCfg.QuotesPath = withDefault(apiCfg.QuotesPath, apiQuotesPath)
+
+	if apiCfg.Retry.Attempts < 1 {
+		apiCfg.Retry.Attempts = 1
+	}
+
+	baseURL, err := url.Parse(apiCfg.BaseURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidBaseURL, apiCfg.BaseURL)
 	}
+
+	return &QuoteAPI{
+		cfg:          apiCfg,
+		httpClient:   httpClient,
+		mu:           sync.Mutex{},
+		limitedUntil: time.Time{},
+	}, nil
 }
 
 // GetRandom returns a random Zen quote or an error if one occurs.
-func (q *QuoteAPI) GetRandom(ctx context.Context) (string, error) {
-	url := fmt.Sprintf("%s%s", apiURL, apiRandomPath)
+func (q *QuoteAPI) GetRandom(ctx context.Context) (quote.Quote, error) {
+	quotes, err := q.fetch(ctx, q.cfg.RandomPath)
+	if err != nil {
+		return quote.Quote{}, err
+	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	return quotes[0], nil
+}
+
+// GetBatch returns a batch of random Zen quotes with a single request.
+func (q *QuoteAPI) GetBatch(ctx context.Context) ([]quote.Quote, error) {
+	return q.fetch(ctx, q.cfg.QuotesPath)
+}
+
+// fetch requests the path, retrying network and server errors with a growing backoff.
+func (q *QuoteAPI) fetch(ctx context.Context, path string) ([]quote.Quote, error) {
+	backoff := q.cfg.Retry.Backoff
+
+	for attempt := 1; ; attempt++ {
+		if wait := q.limitedFor(time.Now()); wait > 0 {
+			return nil, &RateLimitError{RetryAfter: wait}
+		}
+
+		quotes, err := q.fetchOnce(ctx, path)
+
+		var rateLimitErr *RateLimitError
+		if errors.As(err, &rateLimitErr) {
+			q.limit(time.Now(), rateLimitErr.RetryAfter)
+		}
+
+		if err == nil || attempt >= q.cfg.Retry.Attempts || !retryable(ctx, err) {
+			return quotes, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+
+			return nil, fmt.Errorf("%w (after %s)", ctx.Err(), err)
+		case <-timer.C:
+		}
+
+		backoff *= 2
+	}
+}
+
+// limitedFor returns how long requests are still held after a rate limit, 0 if they are not.
+func (q *QuoteAPI) limitedFor(now time.Time) time.Duration {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if now.Before(q.limitedUntil) {
+		return q.limitedUntil.Sub(now)
+	}
+
+	return 0
+}
+
+// limit holds requests for the Retry-After of a rate limit, defaultRetryAfter if it is unknown.
+func (q *QuoteAPI) limit(now time.Time, retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if until := now.Add(retryAfter); until.After(q.limitedUntil) {
+		q.limitedUntil = until
+	}
+}
+
+func (q *QuoteAPI) fetchOnce(ctx context.Context, path string) ([]quote.Quote, error) {
+	reqURL := q.cfg.BaseURL + path
+	if q.cfg.APIKey != "" {
+		reqURL += "/" + url.PathEscape(q.cfg.APIKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("new request failed: %w", err)
+		return nil, fmt.Errorf("new request failed: %w", q.redact(err))
+	}
+
+	for name, value := range q.cfg.Headers {
+		req.Header.Set(name, value)
 	}
 
 	resp, err := q.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to fetch quote: %w", err)
+		return nil, &transportError{err: q.redact(err)}
 	}
 
 	defer func(Body io.ReadCloser) {
 		_ = Body.Close()
 	}(resp.Body)
 
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		return nil, &RateLimitError{RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode}
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var quotes []Quote
+	if err = json.Unmarshal(body, &quotes); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %w", err)
+	}
+
+	if len(quotes) == 0 {
+		return nil, ErrEmptyQuotes
+	}
+
+	// ZenQuotes answers over the limit with a notice in place of the quote
+	if quotes[0].Author == apiNoticeAuthor {
+		return nil, &RateLimitError{RetryAfter: 0}
+	}
+
+	result := make([]quote.Quote, 0, len(quotes))
+	for _, q := range quotes {
+		result = append(result, q.toQuote())
+	}
+
+	return result, nil
+}
+
+// redact takes the API key out of the URL of a url.Error, so errors can be logged.
+func (q *QuoteAPI) redact(err error) error {
+	var urlErr *url.Error
+	if q.cfg.APIKey == "" || !errors.As(err, &urlErr) {
+		return err
+	}
+
+	redacted := strings.ReplaceAll(urlErr.URL, url.PathEscape(q.cfg.APIKey), redactedKey)
+	redacted = strings.ReplaceAll(redacted, q.cfg.APIKey, redactedKey)
+
+	return &url.Error{Op: urlErr.Op, URL: redacted, Err: urlErr.Err}
+}
+
+// transportError is a failure to get a response, the request may succeed when retried.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("failed to fetch quote: %s", e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
+
+// retryable reports whether a request failed on the network or with a server error
+// and the context still allows another attempt. Rate limited requests are not retried.
+func retryable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
 	}
 
-	var quote []Quote
-	if err = json.Unmarshal(body, &quote); err != nil {
-		return "", fmt.Errorf("failed to parse response body: %w", err)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError
 	}
 
-	if len(quote) == 0 {
-		return "", ErrEmptyQuotes
+	var transportErr *transportError
+
+	return errors.As(err, &transportErr)
+}
+
+func withDefault(value string, def string) string {
+	if value == "" {
+		return def
 	}
 
-	return quote[0].Quote, nil
+	return value
+}
+
+// parseRetryAfter parses the Retry-After header given in seconds or as an HTTP date, 0 if absent or invalid.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+
+	return 0
+}
+
+func (q Quote) toQuote() quote.Quote {
+	return quote.Quote{
+		ID:       quote.NewID(q.Author, q.Quote),
+		Text:     q.Quote,
+		Author:   q.Author,
+		Source:   apiSource,
+		Tags:     nil,
+		Language: apiLanguage,
+	}
 }
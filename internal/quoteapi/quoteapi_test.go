@@ -4,11 +4,14 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
+	"sync/atomic"
 	"testing"
+	"time"
+	"zenquote/internal/config"
 	"zenquote/internal/quoteapi"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetRandom(t *testing.T) {
@@ -24,18 +27,13 @@ func TestGetRandom(t *testing.T) {
 		server := httptest.NewTLSServer(http.HandlerFunc(handler))
 		defer server.Close()
 
-		httpClient := server.Client()
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: server.Client().Transport.(*http.Transport).TLSClientConfig,
-			Proxy: func(_ *http.Request) (*url.URL, error) {
-				return url.Parse(server.URL)
-			},
-		}
-
-		quoteAPI := quoteapi.NewQuoteAPI(httpClient)
+		quoteAPI := newTestAPI(t, server, testConfig(server))
 		quote, err := quoteAPI.GetRandom(context.Background())
 		assert.NoError(t, err)
-		assert.Equal(t, "Some random Zen quote", quote)
+		assert.Equal(t, "Some random Zen quote", quote.Text)
+		assert.Equal(t, "Unknown", quote.Author)
+		assert.NotEmpty(t, quote.ID)
+		assert.Contains(t, quote.Source, "zenquotes.io")
 	})
 
 	t.Run("no quotes in response", func(t *testing.T) {
@@ -48,18 +46,268 @@ func TestGetRandom(t *testing.T) {
 		server := httptest.NewTLSServer(http.HandlerFunc(handler))
 		defer server.Close()
 
-		httpClient := server.Client()
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig: server.Client().Transport.(*http.Transport).TLSClientConfig,
-			Proxy: func(_ *http.Request) (*url.URL, error) {
-				return url.Parse(server.URL)
-			},
-		}
-
-		quoteAPI := quoteapi.NewQuoteAPI(httpClient)
+		quoteAPI := newTestAPI(t, server, testConfig(server))
 		quote, err := quoteAPI.GetRandom(context.Background())
 
 		assert.EqualError(t, err, "received empty quotes")
-		assert.Empty(t, quote)
+		assert.Empty(t, quote.Text)
 	})
 }
+
+// testConfig returns the config of an API served by the test server without retries.
+func testConfig(server *httptest.Server) config.Config {
+	var cfg config.Config
+	cfg.Quotes.API = config.QuoteAPI{
+		BaseURL:    server.URL,
+		RandomPath: "",
+		QuotesPath: "",
+		APIKey:     "",
+		Headers:    nil,
+		Timeout:    time.Second,
+		Proxy:      "",
+		CAFile:     "",
+		Retry:      config.Retry{Attempts: 1, Backoff: 0},
+	}
+
+	return cfg
+}
+
+func newTestAPI(t *testing.T, server *httptest.Server, cfg config.Config) *quoteapi.QuoteAPI {
+	t.Helper()
+
+	quoteAPI, err := quoteapi.NewQuoteAPI(cfg, server.Client())
+	require.NoError(t, err)
+
+	return quoteAPI
+}
+
+func TestGetBatch(t *testing.T) {
+	t.Parallel()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/quotes", r.URL.Path)
+
+		_, _ = w.Write([]byte(`[{"q": "First", "a": "One"}, {"q": "Second", "a": "Two"}]`))
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	quotes, err := newTestAPI(t, server, testConfig(server)).GetBatch(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, quotes, 2)
+	assert.Equal(t, "Second", quotes[1].Text)
+	assert.Equal(t, "Two", quotes[1].Author)
+}
+
+func TestRateLimited(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		body       string
+		expected   time.Duration
+	}{
+		{name: "retry after seconds", status: http.StatusTooManyRequests, retryAfter: "30", body: "", expected: 30 * time.Second},
+		{name: "no retry after", status: http.StatusServiceUnavailable, retryAfter: "", body: "", expected: 0},
+		{
+			name:       "notice in place of quote",
+			status:     http.StatusOK,
+			retryAfter: "",
+			body:       `[{"q": "Too many requests. Obtain an auth key for unlimited access.", "a": "zenquotes.io"}]`,
+			expected:   0,
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				if tcCopy.retryAfter != "" {
+					w.Header().Set("Retry-After", tcCopy.retryAfter)
+				}
+
+				w.WriteHeader(tcCopy.status)
+				_, _ = w.Write([]byte(tcCopy.body))
+			}
+			server := httptest.NewTLSServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			_, err := newTestAPI(t, server, testConfig(server)).GetRandom(context.Background())
+			assert.ErrorIs(t, err, quoteapi.ErrRateLimited)
+
+			var rateLimitErr *quoteapi.RateLimitError
+			if assert.ErrorAs(t, err, &rateLimitErr) {
+				assert.Equal(t, tcCopy.expected, rateLimitErr.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimitShared(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int32
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	quoteAPI := newTestAPI(t, server, testConfig(server))
+
+	_, err := quoteAPI.GetRandom(context.Background())
+	assert.ErrorIs(t, err, quoteapi.ErrRateLimited)
+
+	// the batch of the pool waits out the same limit without a request
+	_, err = quoteAPI.GetBatch(context.Background())
+	assert.ErrorIs(t, err, quoteapi.ErrRateLimited)
+	assert.Equal(t, int32(1), requests.Load())
+
+	var rateLimitErr *quoteapi.RateLimitError
+	if assert.ErrorAs(t, err, &rateLimitErr) {
+		assert.Greater(t, rateLimitErr.RetryAfter, time.Duration(0))
+		assert.LessOrEqual(t, rateLimitErr.RetryAfter, 30*time.Second)
+	}
+}
+
+func TestUnexpectedStatus(t *testing.T) {
+	t.Parallel()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	_, err := newTestAPI(t, server, testConfig(server)).GetRandom(context.Background())
+	assert.ErrorIs(t, err, quoteapi.ErrUnexpectedStatus)
+}
+
+func TestRequestSettings(t *testing.T) {
+	t.Parallel()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/random/secret", r.URL.Path)
+		assert.Equal(t, "zenquote", r.Header.Get("User-Agent"))
+
+		_, _ = w.Write([]byte(`[{"q": "Mirrored", "a": "Someone"}]`))
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	cfg := testConfig(server)
+	cfg.Quotes.API.BaseURL = server.URL + "/v2/"
+	cfg.Quotes.API.RandomPath = "/random"
+	cfg.Quotes.API.APIKey = "secret"
+	cfg.Quotes.API.Headers = map[string]string{"User-Agent": "zenquote"}
+
+	quote, err := newTestAPI(t, server, cfg).GetRandom(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "Mirrored", quote.Text)
+}
+
+func TestErrorsHideAPIKey(t *testing.T) {
+	t.Parallel()
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+
+	cfg := testConfig(server)
+	cfg.Quotes.API.APIKey = "s3cr3t key"
+
+	quoteAPI := newTestAPI(t, server, cfg)
+
+	_, err := quoteAPI.GetRandom(context.Background())
+	assert.ErrorIs(t, err, quoteapi.ErrUnexpectedStatus)
+	assert.NotContains(t, err.Error(), "s3cr3t")
+
+	// the transport error carries the request URL
+	server.Close()
+
+	_, err = quoteAPI.GetRandom(context.Background())
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), "s3cr3t")
+	assert.Contains(t, err.Error(), "REDACTED")
+}
+
+func TestRetry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		status   int
+		attempts int
+		expected int32
+	}{
+		{name: "server error retried", status: http.StatusBadGateway, attempts: 3, expected: 3},
+		{name: "client error not retried", status: http.StatusNotFound, attempts: 3, expected: 1},
+		{name: "rate limit not retried", status: http.StatusTooManyRequests, attempts: 3, expected: 1},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			var requests atomic.Int32
+
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				requests.Add(1)
+				w.WriteHeader(tcCopy.status)
+			}
+			server := httptest.NewServer(http.HandlerFunc(handler))
+			defer server.Close()
+
+			cfg := testConfig(server)
+			cfg.Quotes.API.Retry = config.Retry{Attempts: tcCopy.attempts, Backoff: time.Millisecond}
+
+			_, err := newTestAPI(t, server, cfg).GetRandom(context.Background())
+			assert.Error(t, err)
+			assert.Equal(t, tcCopy.expected, requests.Load())
+		})
+	}
+
+	t.Run("succeeds after a failure", func(t *testing.T) {
+		t.Parallel()
+
+		var requests atomic.Int32
+
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			if requests.Add(1) == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+
+				return
+			}
+
+			_, _ = w.Write([]byte(`[{"q": "Second time lucky", "a": "Unknown"}]`))
+		}
+		server := httptest.NewServer(http.HandlerFunc(handler))
+		defer server.Close()
+
+		cfg := testConfig(server)
+		cfg.Quotes.API.Retry = config.Retry{Attempts: 2, Backoff: time.Millisecond}
+
+		quote, err := newTestAPI(t, server, cfg).GetRandom(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, "Second time lucky", quote.Text)
+	})
+}
+
+func TestNewQuoteAPIInvalidURL(t *testing.T) {
+	t.Parallel()
+
+	var cfg config.Config
+	cfg.Quotes.API.BaseURL = "zenquotes.io"
+
+	_, err := quoteapi.NewQuoteAPI(cfg, http.DefaultClient)
+	assert.ErrorIs(t, err, quoteapi.ErrInvalidBaseURL)
+}
@@ -0,0 +1,85 @@
+package quotechain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+
+	"go.uber.org/zap"
+)
+
+const cacheKey = "quotes:cache"
+
+var ErrInvalidCacheSize = errors.New("invalid quote cache size")
+
+// SetRepo stores sets of strings.
+type SetRepo interface {
+	AddToSet(ctx context.Context, key string, member string, maxSize int64) error
+	RandomFromSet(ctx context.Context, key string) (string, error)
+}
+
+// Cache keeps the quotes served by an upstream, so they can still be served while the upstream is down.
+type Cache struct {
+	size int64
+	repo SetRepo
+}
+
+func NewCache(cfg config.Config, repo SetRepo) (*Cache, error) {
+	if cfg.Quotes.Cache.Size <= 0 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidCacheSize, cfg.Quotes.Cache.Size)
+	}
+
+	return &Cache{size: cfg.Quotes.Cache.Size, repo: repo}, nil
+}
+
+// Add stores the quote in the cache.
+func (c *Cache) Add(ctx context.Context, q quote.Quote) error {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("marshal quote failed: %w", err)
+	}
+
+	return c.repo.AddToSet(ctx, cacheKey, string(data), c.size)
+}
+
+// GetRandom returns a random cached quote.
+func (c *Cache) GetRandom(ctx context.Context) (quote.Quote, error) {
+	data, err := c.repo.RandomFromSet(ctx, cacheKey)
+	if err != nil {
+		return quote.Quote{}, err
+	}
+
+	var q quote.Quote
+	if err = json.Unmarshal([]byte(data), &q); err != nil {
+		return quote.Quote{}, fmt.Errorf("unmarshal cached quote failed: %w", err)
+	}
+
+	return q, nil
+}
+
+// CacheThrough adds every quote served by repo to the cache.
+func CacheThrough(repo Repo, cache *Cache, logger *zap.Logger) Repo {
+	return &cacheThrough{repo: repo, cache: cache, logger: logger}
+}
+
+type cacheThrough struct {
+	repo   Repo
+	cache  *Cache
+	logger *zap.Logger
+}
+
+func (c *cacheThrough) GetRandom(ctx context.Context) (quote.Quote, error) {
+	q, err := c.repo.GetRandom(ctx)
+	if err != nil {
+		return quote.Quote{}, err
+	}
+
+	if err = c.cache.Add(ctx, q); err != nil {
+		c.logger.Error("cache quote failed", zap.Error(err), zap.String("id", q.ID))
+	}
+
+	return q, nil
+}
@@ -0,0 +1,74 @@
+package quotechain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var errEmptySet = errors.New("empty set")
+
+type MockSetRepo struct {
+	mu   sync.Mutex
+	sets map[string][]string
+}
+
+func (mr *MockSetRepo) AddToSet(_ context.Context, key string, member string, maxSize int64) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	mr.sets[key] = append(mr.sets[key], member)
+	if int64(len(mr.sets[key])) > maxSize {
+		mr.sets[key] = mr.sets[key][1:]
+	}
+
+	return nil
+}
+
+func (mr *MockSetRepo) RandomFromSet(_ context.Context, key string) (string, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if len(mr.sets[key]) == 0 {
+		return "", errEmptySet
+	}
+
+	return mr.sets[key][0], nil
+}
+
+func TestCacheThrough(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.Quotes.Cache.Size = 1
+
+	cache, err := NewCache(cfg, &MockSetRepo{mu: sync.Mutex{}, sets: make(map[string][]string)})
+	require.NoError(t, err)
+
+	_, err = cache.GetRandom(context.Background())
+	assert.ErrorIs(t, err, errEmptySet)
+
+	served := quote.Quote{ID: "1", Text: "text", Author: "author", Source: "api", Tags: []string{"zen"}, Language: "en"}
+	api := CacheThrough(&MockRepo{GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+		return served, nil
+	}}, cache, zap.NewNop())
+
+	q, err := api.GetRandom(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, served, q)
+
+	cached, err := cache.GetRandom(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, served, cached)
+
+	cfg.Quotes.Cache.Size = 0
+	_, err = NewCache(cfg, nil)
+	assert.ErrorIs(t, err, ErrInvalidCacheSize)
+}
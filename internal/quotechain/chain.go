@@ -0,0 +1,258 @@
+package quotechain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrNoProvider     = errors.New("no quote provider served a quote")
+	ErrNoProviders    = errors.New("no quote providers configured")
+	ErrInvalidBreaker = errors.New("invalid circuit breaker config")
+
+	ErrInvalidStatsInterval = errors.New("invalid quote stats interval")
+
+	errNoSelector = errors.New("quote provider can not filter quotes")
+)
+
+// Repo is a quote source.
+type Repo interface {
+	GetRandom(ctx context.Context) (quote.Quote, error)
+}
+
+// Provider is a named quote source of the chain.
+type Provider struct {
+	Name    string
+	Repo    Repo
+	Timeout time.Duration // limit of a single attempt, 0 for no limit
+}
+
+// Stats counts the outcomes of the attempts of a provider.
+type Stats struct {
+	Served  int64 // quotes served
+	Failed  int64 // failed attempts
+	Skipped int64 // attempts skipped while the circuit breaker was open
+}
+
+// Chain serves quotes from the first provider that succeeds, in the configured order.
+// A provider failing repeatedly is skipped for a cooldown, so a dead upstream does not
+// add its timeout to every request.
+type Chain struct {
+	logger        *zap.Logger
+	providers     []*provider
+	now           func() time.Time
+	statsInterval time.Duration
+	closeChan     chan struct{}
+}
+
+// getFunc gets a quote from the repo of a provider.
+type getFunc func(ctx context.Context, repo Repo) (quote.Quote, error)
+
+type provider struct {
+	Provider
+	breaker *breaker
+	served  atomic.Int64
+	failed  atomic.Int64
+	skipped atomic.Int64
+}
+
+func NewChain(cfg config.Config, logger *zap.Logger, providers ...Provider) (*Chain, error) {
+	if cfg.Quotes.Breaker.Failures <= 0 || cfg.Quotes.Breaker.Cooldown < 0 {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidBreaker, cfg.Quotes.Breaker)
+	}
+
+	if len(providers) == 0 {
+		return nil, ErrNoProviders
+	}
+
+	if cfg.Quotes.StatsInterval < 0 {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidStatsInterval, cfg.Quotes.StatsInterval)
+	}
+
+	chain := &Chain{
+		logger:        logger,
+		providers:     make([]*provider, 0, len(providers)),
+		now:           time.Now,
+		statsInterval: cfg.Quotes.StatsInterval,
+		closeChan:     make(chan struct{}),
+	}
+
+	for _, p := range providers {
+		chain.providers = append(chain.providers, &provider{
+			Provider: p,
+			breaker:  &breaker{mu: sync.Mutex{}, cfg: cfg.Quotes.Breaker, failures: 0, openUntil: time.Time{}},
+			served:   atomic.Int64{},
+			failed:   atomic.Int64{},
+			skipped:  atomic.Int64{},
+		})
+	}
+
+	return chain, nil
+}
+
+// GetRandom returns a random quote from the first provider that serves one.
+func (c *Chain) GetRandom(ctx context.Context) (quote.Quote, error) {
+	return c.serve(ctx, func(ctx context.Context, repo Repo) (quote.Quote, error) {
+		return repo.GetRandom(ctx)
+	})
+}
+
+// Select returns a quote matching the filter from the first provider that serves one.
+// Providers unable to filter are passed over, ErrNoMatch is returned if no provider has a match.
+func (c *Chain) Select(ctx context.Context, filter quote.Filter) (quote.Quote, error) {
+	return c.serve(ctx, func(ctx context.Context, repo Repo) (quote.Quote, error) {
+		selector, ok := repo.(quote.Selector)
+		if !ok {
+			return quote.Quote{}, errNoSelector
+		}
+
+		return selector.Select(ctx, filter)
+	})
+}
+
+// serve gets a quote from the providers in order. A provider having no matching quote
+// or not supporting the request is not failing, its breaker is left as is.
+func (c *Chain) serve(ctx context.Context, get getFunc) (quote.Quote, error) {
+	var (
+		errs    []error
+		noMatch bool
+	)
+
+	for _, p := range c.providers {
+		if !p.breaker.allow(c.now()) {
+			p.skipped.Add(1)
+
+			continue
+		}
+
+		q, err := p.get(ctx, get)
+		if errors.Is(err, quote.ErrNoMatch) || errors.Is(err, errNoSelector) {
+			noMatch = true
+
+			continue
+		}
+
+		if err != nil {
+			p.failed.Add(1)
+			p.breaker.failure(c.now())
+			c.logger.Warn("quote provider failed", zap.String("provider", p.Name), zap.Error(err))
+
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+
+			continue
+		}
+
+		p.breaker.success()
+		p.served.Add(1)
+		c.logger.Debug("quote served", zap.String("provider", p.Name), zap.String("id", q.ID))
+
+		return q, nil
+	}
+
+	if noMatch {
+		errs = append(errs, quote.ErrNoMatch)
+	}
+
+	return quote.Quote{}, fmt.Errorf("%w: %w", ErrNoProvider, errors.Join(errs...))
+}
+
+// Stats returns the counters of each provider by name.
+func (c *Chain) Stats() map[string]Stats {
+	stats := make(map[string]Stats, len(c.providers))
+	for _, p := range c.providers {
+		stats[p.Name] = Stats{Served: p.served.Load(), Failed: p.failed.Load(), Skipped: p.skipped.Load()}
+	}
+
+	return stats
+}
+
+// Start logs the counters of each provider every stats interval until Stop is called.
+// It returns immediately when the interval is 0.
+func (c *Chain) Start() {
+	if c.statsInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(c.statsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closeChan:
+			return
+		case <-ticker.C:
+			c.logStats()
+		}
+	}
+}
+
+// Stop stops logging the counters. It must be called at most once.
+func (c *Chain) Stop() {
+	close(c.closeChan)
+}
+
+// logStats logs the counters of each provider, they count from the start of the server.
+func (c *Chain) logStats() {
+	for _, p := range c.providers {
+		c.logger.Info("quote provider stats",
+			zap.String("provider", p.Name),
+			zap.Int64("served", p.served.Load()),
+			zap.Int64("failed", p.failed.Load()),
+			zap.Int64("skipped", p.skipped.Load()),
+		)
+	}
+}
+
+func (p *provider) get(ctx context.Context, get getFunc) (quote.Quote, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	return get(ctx, p.Repo)
+}
+
+// breaker opens after cfg.Failures consecutive failures and stays open for cfg.Cooldown.
+// After the cooldown attempts are let through again, the first failure reopens it
+// and the first success closes it.
+type breaker struct {
+	mu        sync.Mutex
+	cfg       config.Breaker
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return !now.Before(b.openUntil)
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.openUntil = time.Time{}
+}
+
+func (b *breaker) failure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.failures >= b.cfg.Failures {
+		b.openUntil = now.Add(b.cfg.Cooldown)
+	}
+}
@@ -0,0 +1,208 @@
+package quotechain
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var errUpstream = errors.New("upstream down")
+
+type MockRepo struct {
+	GetRandomFunc func(ctx context.Context) (quote.Quote, error)
+}
+
+func (mr *MockRepo) GetRandom(ctx context.Context) (quote.Quote, error) {
+	return mr.GetRandomFunc(ctx)
+}
+
+type MockSelector struct {
+	MockRepo
+	SelectFunc func(ctx context.Context, filter quote.Filter) (quote.Quote, error)
+}
+
+func (ms *MockSelector) Select(ctx context.Context, filter quote.Filter) (quote.Quote, error) {
+	return ms.SelectFunc(ctx, filter)
+}
+
+func testConfig() config.Config {
+	cfg := config.Config{}
+	cfg.Quotes.Breaker = config.Breaker{Failures: 2, Cooldown: time.Minute}
+
+	return cfg
+}
+
+func TestChainFallback(t *testing.T) {
+	t.Parallel()
+
+	calls := 0
+	failing := &MockRepo{GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+		calls++
+
+		return quote.Quote{}, errUpstream
+	}}
+	local := &MockRepo{GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+		return quote.Quote{ID: "1", Text: "local", Author: "", Source: "", Tags: nil, Language: ""}, nil
+	}}
+
+	chain, err := NewChain(testConfig(), zap.NewNop(),
+		Provider{Name: "api", Repo: failing, Timeout: 0},
+		Provider{Name: "local", Repo: local, Timeout: 0},
+	)
+	require.NoError(t, err)
+
+	now := time.Now()
+	chain.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		q, err := chain.GetRandom(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, "local", q.Text)
+	}
+
+	// the breaker opened after two failures, the third request skipped the api
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, map[string]Stats{
+		"api":   {Served: 0, Failed: 2, Skipped: 1},
+		"local": {Served: 3, Failed: 0, Skipped: 0},
+	}, chain.Stats())
+
+	// after the cooldown the api is tried again and a failure reopens the breaker at once
+	now = now.Add(time.Minute)
+
+	_, _ = chain.GetRandom(context.Background())
+	_, _ = chain.GetRandom(context.Background())
+	assert.Equal(t, 3, calls)
+}
+
+func TestChainTimeout(t *testing.T) {
+	t.Parallel()
+
+	slow := &MockRepo{GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+		<-ctx.Done()
+
+		return quote.Quote{}, ctx.Err()
+	}}
+
+	chain, err := NewChain(testConfig(), zap.NewNop(), Provider{Name: "api", Repo: slow, Timeout: 10 * time.Millisecond})
+	require.NoError(t, err)
+
+	_, err = chain.GetRandom(context.Background())
+	assert.ErrorIs(t, err, ErrNoProvider)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestBreakerRecovers(t *testing.T) {
+	t.Parallel()
+
+	b := &breaker{mu: sync.Mutex{}, cfg: config.Breaker{Failures: 1, Cooldown: time.Second}, failures: 0, openUntil: time.Time{}}
+	now := time.Now()
+
+	assert.True(t, b.allow(now))
+	b.failure(now)
+	assert.False(t, b.allow(now))
+	assert.True(t, b.allow(now.Add(time.Second)))
+
+	b.success()
+	assert.True(t, b.allow(now))
+}
+
+func TestNewChainInvalid(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewChain(config.Config{}, zap.NewNop())
+	assert.ErrorIs(t, err, ErrInvalidBreaker)
+
+	_, err = NewChain(testConfig(), zap.NewNop())
+	assert.ErrorIs(t, err, ErrNoProviders)
+
+	cfg := testConfig()
+	cfg.Quotes.StatsInterval = -time.Second
+
+	_, err = NewChain(cfg, zap.NewNop(), Provider{Name: "local", Repo: &MockRepo{GetRandomFunc: nil}, Timeout: 0})
+	assert.ErrorIs(t, err, ErrInvalidStatsInterval)
+}
+
+func TestChainLogStats(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zap.InfoLevel)
+
+	cfg := testConfig()
+	cfg.Quotes.StatsInterval = 10 * time.Millisecond
+
+	local := &MockRepo{GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+		return quote.Quote{ID: "1", Text: "local", Author: "", Source: "", Tags: nil, Language: ""}, nil
+	}}
+
+	chain, err := NewChain(cfg, zap.New(core), Provider{Name: "local", Repo: local, Timeout: 0})
+	require.NoError(t, err)
+
+	_, err = chain.GetRandom(context.Background())
+	require.NoError(t, err)
+
+	go chain.Start()
+	defer chain.Stop()
+
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("quote provider stats").Len() > 0
+	}, time.Second, time.Millisecond)
+
+	fields := logs.FilterMessage("quote provider stats").All()[0].ContextMap()
+	assert.Equal(t, map[string]interface{}{"provider": "local", "served": int64(1), "failed": int64(0), "skipped": int64(0)}, fields)
+}
+
+func TestChainSelect(t *testing.T) {
+	t.Parallel()
+
+	api := &MockRepo{GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+		return quote.Quote{ID: "1", Text: "api", Author: "", Source: "", Tags: nil, Language: ""}, nil
+	}}
+	empty := &MockSelector{
+		MockRepo: MockRepo{GetRandomFunc: nil},
+		SelectFunc: func(ctx context.Context, filter quote.Filter) (quote.Quote, error) {
+			return quote.Quote{}, quote.ErrNoMatch
+		},
+	}
+	local := &MockSelector{
+		MockRepo: MockRepo{GetRandomFunc: nil},
+		SelectFunc: func(ctx context.Context, filter quote.Filter) (quote.Quote, error) {
+			return quote.Quote{ID: "2", Text: "local", Author: filter.Author, Source: "", Tags: nil, Language: ""}, nil
+		},
+	}
+
+	filter := quote.Filter{Author: "Lao Tzu", Tag: "", Keyword: "", Language: "", Daily: false}
+
+	chain, err := NewChain(testConfig(), zap.NewNop(),
+		Provider{Name: "api", Repo: api, Timeout: 0},
+		Provider{Name: "redis", Repo: empty, Timeout: 0},
+		Provider{Name: "local", Repo: local, Timeout: 0},
+	)
+	require.NoError(t, err)
+
+	// the api can not filter and the redis provider has no match, neither is failing
+	q, err := chain.Select(context.Background(), filter)
+	require.NoError(t, err)
+	assert.Equal(t, "local", q.Text)
+	assert.Equal(t, "Lao Tzu", q.Author)
+	assert.Equal(t, Stats{Served: 0, Failed: 0, Skipped: 0}, chain.Stats()["api"])
+	assert.Equal(t, Stats{Served: 0, Failed: 0, Skipped: 0}, chain.Stats()["redis"])
+
+	chain, err = NewChain(testConfig(), zap.NewNop(),
+		Provider{Name: "api", Repo: api, Timeout: 0},
+		Provider{Name: "redis", Repo: empty, Timeout: 0},
+	)
+	require.NoError(t, err)
+
+	_, err = chain.Select(context.Background(), filter)
+	assert.ErrorIs(t, err, quote.ErrNoMatch)
+}
@@ -0,0 +1,189 @@
+package quotepool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+	"zenquote/internal/quoteapi"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrPoolEmpty     = errors.New("quote pool is empty")
+	ErrInvalidConfig = errors.New("invalid quote pool config")
+)
+
+// Fetcher fetches a batch of quotes from an upstream.
+type Fetcher interface {
+	GetBatch(ctx context.Context) ([]quote.Quote, error)
+}
+
+// Pool serves quotes prefetched in batches, so solved clients do not wait for the upstream
+// and the upstream gets one request per batch instead of one per quote.
+// The pool is refilled in the background when it drops below the low watermark,
+// no more often than MinInterval and backing off when the upstream fails or asks to retry later.
+type Pool struct {
+	cfg       config.QuotePool
+	logger    *zap.Logger
+	fetcher   Fetcher
+	mu        sync.Mutex
+	quotes    []quote.Quote
+	failures  int
+	refill    chan struct{}
+	closeChan chan struct{}
+}
+
+func NewPool(cfg config.Config, logger *zap.Logger, fetcher Fetcher) (*Pool, error) {
+	poolCfg := cfg.Quotes.Pool
+	if poolCfg.Size <= 0 || poolCfg.LowWatermark <= 0 || poolCfg.LowWatermark > poolCfg.Size ||
+		poolCfg.MinInterval <= 0 || poolCfg.MaxBackoff < poolCfg.MinInterval || poolCfg.FetchTimeout <= 0 {
+		return nil, fmt.Errorf("%w: %+v", ErrInvalidConfig, poolCfg)
+	}
+
+	return &Pool{
+		cfg:       poolCfg,
+		logger:    logger,
+		fetcher:   fetcher,
+		mu:        sync.Mutex{},
+		quotes:    make([]quote.Quote, 0, poolCfg.Size),
+		failures:  0,
+		refill:    make(chan struct{}, 1),
+		closeChan: make(chan struct{}),
+	}, nil
+}
+
+// GetRandom takes a random quote out of the pool, so a quote is served once per batch.
+func (p *Pool) GetRandom(_ context.Context) (quote.Quote, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.quotes) == 0 {
+		p.requestRefill()
+
+		return quote.Quote{}, ErrPoolEmpty
+	}
+
+	i := rand.Intn(len(p.quotes))
+	q := p.quotes[i]
+
+	last := len(p.quotes) - 1
+	p.quotes[i] = p.quotes[last]
+	p.quotes = p.quotes[:last]
+
+	if len(p.quotes) < p.cfg.LowWatermark {
+		p.requestRefill()
+	}
+
+	return q, nil
+}
+
+// Len returns the number of quotes in the pool.
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return len(p.quotes)
+}
+
+// Start fills the pool and keeps refilling it until Stop is called. It blocks, run it in a goroutine.
+func (p *Pool) Start() {
+	var next time.Time // earliest time of the next fetch
+
+	for {
+		var timer *time.Timer
+
+		wakeup := make(<-chan time.Time)
+
+		if p.Len() < p.cfg.LowWatermark {
+			delay := time.Until(next)
+			if delay <= 0 {
+				next = time.Now().Add(p.fill())
+
+				continue
+			}
+
+			timer = time.NewTimer(delay)
+			wakeup = timer.C
+		}
+
+		select {
+		case <-p.closeChan:
+			return
+		case <-p.refill:
+		case <-wakeup:
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Stop stops refilling the pool. Calling Stop more than once panics.
+func (p *Pool) Stop() {
+	close(p.closeChan)
+}
+
+// fill fetches a batch into the pool and returns how long to wait before the next fetch.
+func (p *Pool) fill() time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.FetchTimeout)
+	defer cancel()
+
+	quotes, err := p.fetcher.GetBatch(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err != nil {
+		p.failures++
+		delay := p.backoff()
+
+		var rateLimitErr *quoteapi.RateLimitError
+		if errors.As(err, &rateLimitErr) && rateLimitErr.RetryAfter > delay {
+			delay = rateLimitErr.RetryAfter
+		}
+
+		p.logger.Warn("quote pool fetch failed", zap.Error(err), zap.Duration("retryIn", delay))
+
+		return delay
+	}
+
+	p.failures = 0
+
+	free := p.cfg.Size - len(p.quotes)
+	if len(quotes) > free {
+		quotes = quotes[:free]
+	}
+
+	p.quotes = append(p.quotes, quotes...)
+	p.logger.Debug("quote pool filled", zap.Int("fetched", len(quotes)), zap.Int("size", len(p.quotes)))
+
+	return p.cfg.MinInterval
+}
+
+// backoff doubles the wait after each consecutive failure, up to MaxBackoff.
+func (p *Pool) backoff() time.Duration {
+	delay := p.cfg.MinInterval
+	for i := 1; i < p.failures && delay < p.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > p.cfg.MaxBackoff {
+		delay = p.cfg.MaxBackoff
+	}
+
+	return delay
+}
+
+func (p *Pool) requestRefill() {
+	select {
+	case p.refill <- struct{}{}:
+	default:
+	}
+}
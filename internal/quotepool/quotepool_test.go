@@ -0,0 +1,129 @@
+package quotepool
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+	"zenquote/internal/quoteapi"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+var errUpstream = errors.New("upstream down")
+
+type MockFetcher struct {
+	GetBatchFunc func(ctx context.Context) ([]quote.Quote, error)
+}
+
+func (mf *MockFetcher) GetBatch(ctx context.Context) ([]quote.Quote, error) {
+	return mf.GetBatchFunc(ctx)
+}
+
+func testConfig() config.Config {
+	cfg := config.Config{}
+	cfg.Quotes.Pool = config.QuotePool{
+		Size:         5,
+		LowWatermark: 2,
+		MinInterval:  time.Millisecond,
+		MaxBackoff:   8 * time.Millisecond,
+		FetchTimeout: time.Second,
+	}
+
+	return cfg
+}
+
+func batch(n int) []quote.Quote {
+	quotes := make([]quote.Quote, 0, n)
+	for i := 0; i < n; i++ {
+		quotes = append(quotes, quote.Quote{
+			ID: strconv.Itoa(i), Text: "quote " + strconv.Itoa(i), Author: "", Source: "", Tags: nil, Language: "",
+		})
+	}
+
+	return quotes
+}
+
+func TestPoolRefill(t *testing.T) {
+	t.Parallel()
+
+	var fetches atomic.Int64
+
+	fetcher := &MockFetcher{GetBatchFunc: func(ctx context.Context) ([]quote.Quote, error) {
+		fetches.Add(1)
+
+		return batch(4), nil
+	}}
+
+	pool, err := NewPool(testConfig(), zap.NewNop(), fetcher)
+	require.NoError(t, err)
+
+	_, err = pool.GetRandom(context.Background())
+	assert.ErrorIs(t, err, ErrPoolEmpty)
+
+	go pool.Start()
+	defer pool.Stop()
+
+	// the first fetch fills 4 quotes, above the low watermark of 2
+	require.Eventually(t, func() bool { return pool.Len() == 4 }, time.Second, time.Millisecond)
+
+	served := make(map[string]bool)
+
+	for i := 0; i < 3; i++ {
+		q, err := pool.GetRandom(context.Background())
+		require.NoError(t, err)
+		assert.False(t, served[q.Text], "quote served twice from one batch")
+
+		served[q.Text] = true
+	}
+
+	// dropping below the watermark triggers a refill capped at the pool size
+	require.Eventually(t, func() bool { return pool.Len() == 5 }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), fetches.Load())
+}
+
+func TestPoolBackoff(t *testing.T) {
+	t.Parallel()
+
+	fetchErr := error(errUpstream)
+	fetcher := &MockFetcher{GetBatchFunc: func(ctx context.Context) ([]quote.Quote, error) {
+		return nil, fetchErr
+	}}
+
+	pool, err := NewPool(testConfig(), zap.NewNop(), fetcher)
+	require.NoError(t, err)
+
+	// the wait doubles with every failure up to the max backoff
+	assert.Equal(t, time.Millisecond, pool.fill())
+	assert.Equal(t, 2*time.Millisecond, pool.fill())
+	assert.Equal(t, 4*time.Millisecond, pool.fill())
+	assert.Equal(t, 8*time.Millisecond, pool.fill())
+	assert.Equal(t, 8*time.Millisecond, pool.fill())
+
+	// Retry-After takes precedence over a shorter backoff
+	fetchErr = &quoteapi.RateLimitError{RetryAfter: time.Minute}
+	assert.Equal(t, time.Minute, pool.fill())
+
+	// a successful fetch resets the backoff
+	fetcher.GetBatchFunc = func(ctx context.Context) ([]quote.Quote, error) {
+		return batch(1), nil
+	}
+	assert.Equal(t, time.Millisecond, pool.fill())
+	assert.Equal(t, 1, pool.Len())
+}
+
+func TestNewPoolInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig()
+	cfg.Quotes.Pool.LowWatermark = cfg.Quotes.Pool.Size + 1
+
+	_, err := NewPool(cfg, zap.NewNop(), nil)
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
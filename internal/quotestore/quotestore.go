@@ -0,0 +1,188 @@
+package quotestore
+
+import (
+	"bytes"
+	"context"
+	_ "embed" // bundled quotes
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+)
+
+const defaultLanguage = "en"
+
+var (
+	ErrEmptyStore   = errors.New("quote store is empty")
+	ErrInvalidQuote = errors.New("invalid quote")
+)
+
+//go:embed quotes.json
+var bundled []byte
+
+// Record is a quote in the JSON dataset. The id is derived from the author and the text if empty.
+type Record struct {
+	ID       string   `json:"id,omitempty"`
+	Text     string   `json:"text"`
+	Author   string   `json:"author,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
+	Language string   `json:"language,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"` // kept in the dataset but never served
+}
+
+// Store serves quotes from a local dataset, it needs no network.
+type Store struct {
+	quotes []quote.Quote
+}
+
+// NewStore loads the configured quotes file or the bundled dataset.
+func NewStore(cfg config.Config) (*Store, error) {
+	data := bundled
+
+	if file := cfg.Quotes.Store.File; file != "" {
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("read quotes file failed: %w", err)
+		}
+	}
+
+	quotes, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(quotes) == 0 {
+		return nil, ErrEmptyStore
+	}
+
+	return &Store{quotes: quotes}, nil
+}
+
+// GetRandom returns a quote drawn uniformly at random.
+func (s *Store) GetRandom(_ context.Context) (quote.Quote, error) {
+	return s.quotes[rand.Intn(len(s.quotes))], nil
+}
+
+// Select returns a random quote matching the filter, or the quote of the day among them.
+func (s *Store) Select(_ context.Context, filter quote.Filter) (quote.Quote, error) {
+	matching := make([]quote.Quote, 0, len(s.quotes))
+
+	for _, q := range s.quotes {
+		if filter.Matches(q) {
+			matching = append(matching, q)
+		}
+	}
+
+	if len(matching) == 0 {
+		return quote.Quote{}, quote.ErrNoMatch
+	}
+
+	if filter.Daily {
+		return matching[quote.DailyIndex(time.Now(), len(matching))], nil
+	}
+
+	return matching[rand.Intn(len(matching))], nil
+}
+
+// Len returns the number of quotes in the store.
+func (s *Store) Len() int {
+	return len(s.quotes)
+}
+
+// QuoteID returns the id of the quote served from the record.
+func (r Record) QuoteID() string {
+	if r.ID != "" {
+		return r.ID
+	}
+
+	return quote.NewID(strings.TrimSpace(r.Author), strings.TrimSpace(r.Text))
+}
+
+// ReadRecords parses a JSON dataset.
+func ReadRecords(data []byte) ([]Record, error) {
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse quotes failed: %w", err)
+	}
+
+	return records, nil
+}
+
+// WriteRecords writes a JSON dataset with a record per line, the format of the bundled one.
+func WriteRecords(w io.Writer, records []Record) error {
+	var line bytes.Buffer
+
+	encoder := json.NewEncoder(&line)
+	encoder.SetEscapeHTML(false)
+
+	buf := bytes.NewBufferString("[\n")
+
+	for i, r := range records {
+		line.Reset()
+
+		if err := encoder.Encode(r); err != nil {
+			return fmt.Errorf("marshal quote failed: %w", err)
+		}
+
+		buf.WriteString("  ")
+		buf.Write(bytes.TrimSuffix(line.Bytes(), []byte("\n")))
+
+		if i < len(records)-1 {
+			buf.WriteByte(',')
+		}
+
+		buf.WriteByte('\n')
+	}
+
+	buf.WriteString("]\n")
+
+	if _, err := buf.WriteTo(w); err != nil {
+		return fmt.Errorf("write quotes failed: %w", err)
+	}
+
+	return nil
+}
+
+func parse(data []byte) ([]quote.Quote, error) {
+	records, err := ReadRecords(data)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := make([]quote.Quote, 0, len(records))
+
+	for i, r := range records {
+		if r.Disabled {
+			continue
+		}
+
+		text := strings.TrimSpace(r.Text)
+		if text == "" {
+			return nil, fmt.Errorf("%w: quote %d has no text", ErrInvalidQuote, i)
+		}
+
+		q := quote.Quote{
+			ID:       r.QuoteID(),
+			Text:     text,
+			Author:   strings.TrimSpace(r.Author),
+			Source:   r.Source,
+			Tags:     r.Tags,
+			Language: r.Language,
+		}
+
+		if q.Language == "" {
+			q.Language = defaultLanguage
+		}
+
+		quotes = append(quotes, q)
+	}
+
+	return quotes, nil
+}
@@ -0,0 +1,182 @@
+package quotestore_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"zenquote/internal/config"
+	"zenquote/internal/quote"
+	"zenquote/internal/quotestore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConfig(file string) config.Config {
+	cfg := config.Config{}
+	cfg.Quotes.Store.File = file
+
+	return cfg
+}
+
+func TestNewStoreBundled(t *testing.T) {
+	t.Parallel()
+
+	store, err := quotestore.NewStore(newConfig(""))
+	require.NoError(t, err)
+	assert.Greater(t, store.Len(), 0)
+
+	quote, err := store.GetRandom(context.Background())
+	require.NoError(t, err)
+	assert.NotEmpty(t, quote.Text)
+	assert.NotEmpty(t, quote.Author)
+	assert.NotEmpty(t, quote.ID)
+	assert.Equal(t, "en", quote.Language)
+}
+
+func TestNewStoreFile(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{
+			name:    "valid",
+			content: `[{"id": "q1", "text": " Be here now. ", "author": "Ram Dass"}, {"text": "Breathe.", "language": "de"}]`,
+			wantErr: nil,
+		},
+		{name: "empty", content: `[]`, wantErr: quotestore.ErrEmptyStore},
+		{name: "no text", content: `[{"author": "Nobody"}]`, wantErr: quotestore.ErrInvalidQuote},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			file := filepath.Join(t.TempDir(), "quotes.json")
+			require.NoError(t, os.WriteFile(file, []byte(tcCopy.content), 0o600))
+
+			store, err := quotestore.NewStore(newConfig(file))
+			if tcCopy.wantErr != nil {
+				assert.ErrorIs(t, err, tcCopy.wantErr)
+
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, 2, store.Len())
+
+			seen := make(map[string]bool)
+			for i := 0; i < 100; i++ {
+				quote, err := store.GetRandom(context.Background())
+				require.NoError(t, err)
+
+				seen[quote.Text] = true
+			}
+
+			// both quotes are drawn, the text is trimmed
+			assert.Equal(t, map[string]bool{"Be here now.": true, "Breathe.": true}, seen)
+		})
+	}
+}
+
+func TestNewStoreMissingFile(t *testing.T) {
+	t.Parallel()
+
+	_, err := quotestore.NewStore(newConfig(filepath.Join(t.TempDir(), "missing.json")))
+	assert.Error(t, err)
+}
+
+func TestSelect(t *testing.T) {
+	t.Parallel()
+
+	store, err := quotestore.NewStore(newConfig(""))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		filter  quote.Filter
+		wantErr error
+	}{
+		{name: "author", filter: quote.Filter{Author: "lao tzu", Tag: "", Keyword: "", Language: "", Daily: false}, wantErr: nil},
+		{name: "tag", filter: quote.Filter{Author: "", Tag: "Patience", Keyword: "", Language: "", Daily: false}, wantErr: nil},
+		{name: "keyword", filter: quote.Filter{Author: "", Tag: "", Keyword: "journey", Language: "", Daily: false}, wantErr: nil},
+		{name: "language", filter: quote.Filter{Author: "", Tag: "", Keyword: "", Language: "en", Daily: true}, wantErr: nil},
+		{
+			name:    "no match",
+			filter:  quote.Filter{Author: "", Tag: "", Keyword: "", Language: "de", Daily: false},
+			wantErr: quote.ErrNoMatch,
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			for i := 0; i < 20; i++ {
+				q, err := store.Select(context.Background(), tcCopy.filter)
+				if tcCopy.wantErr != nil {
+					assert.ErrorIs(t, err, tcCopy.wantErr)
+
+					return
+				}
+
+				require.NoError(t, err)
+				assert.True(t, tcCopy.filter.Matches(q))
+			}
+		})
+	}
+}
+
+func TestSelectDaily(t *testing.T) {
+	t.Parallel()
+
+	store, err := quotestore.NewStore(newConfig(""))
+	require.NoError(t, err)
+
+	filter := quote.Filter{Author: "", Tag: "", Keyword: "", Language: "", Daily: true}
+
+	daily, err := store.Select(context.Background(), filter)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		q, err := store.Select(context.Background(), filter)
+		require.NoError(t, err)
+		assert.Equal(t, daily.ID, q.ID)
+	}
+}
+
+func TestWriteRecords(t *testing.T) {
+	t.Parallel()
+
+	records := []quotestore.Record{
+		{ID: "q1", Text: "Less & more.", Author: "Anonymous", Source: "", Tags: []string{"simplicity"}, Language: "en", Disabled: false},
+		{ID: "q2", Text: "Hidden.", Author: "", Source: "", Tags: nil, Language: "", Disabled: true},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, quotestore.WriteRecords(&buf, records))
+	assert.Equal(t, `[
+  {"id":"q1","text":"Less & more.","author":"Anonymous","tags":["simplicity"],"language":"en"},
+  {"id":"q2","text":"Hidden.","disabled":true}
+]
+`, buf.String())
+
+	read, err := quotestore.ReadRecords(buf.Bytes())
+	require.NoError(t, err)
+	assert.Equal(t, records, read)
+
+	// disabled quotes are never served
+	file := filepath.Join(t.TempDir(), "quotes.json")
+	require.NoError(t, os.WriteFile(file, buf.Bytes(), 0o600))
+
+	store, err := quotestore.NewStore(newConfig(file))
+	require.NoError(t, err)
+	assert.Equal(t, 1, store.Len())
+}
@@ -0,0 +1,183 @@
+package ratelimit
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+	"zenquote/internal/config"
+)
+
+const (
+	ipv4Bits = 32
+	ipv6Bits = 128
+
+	evictScan = 16 // least recently used buckets looked at for a full one to drop
+)
+
+var (
+	ErrInvalidBucket = errors.New("invalid rate limit bucket")
+	ErrInvalidPrefix = errors.New("invalid rate limit subnet prefix")
+)
+
+// Limiter takes a token from the bucket of the client IP and from the bucket of its subnet
+// for each allowed event. Buckets live in memory, each server instance limits on its own.
+type Limiter struct {
+	mu      sync.Mutex
+	cfg     config.RateLimit
+	budget  config.Budget
+	buckets map[string]*list.Element
+	order   *list.List // buckets ordered by last use
+	now     func() time.Time
+}
+
+type bucket struct {
+	key     string
+	cfg     config.Bucket
+	tokens  float64
+	updated time.Time
+}
+
+// Limits are the limiters of the TCP server.
+type Limits struct {
+	Connections *Limiter // new connections
+	Challenges  *Limiter // issued challenges
+}
+
+func NewLimits(cfg config.Config) (*Limits, error) {
+	connections, err := New(cfg.TCP.RateLimit, cfg.TCP.RateLimit.Connections)
+	if err != nil {
+		return nil, fmt.Errorf("connections: %w", err)
+	}
+
+	challenges, err := New(cfg.TCP.RateLimit, cfg.TCP.RateLimit.Challenges)
+	if err != nil {
+		return nil, fmt.Errorf("challenges: %w", err)
+	}
+
+	return &Limits{Connections: connections, Challenges: challenges}, nil
+}
+
+func New(cfg config.RateLimit, budget config.Budget) (*Limiter, error) {
+	if cfg.Enabled {
+		for _, b := range []config.Bucket{budget.IP, budget.Subnet} {
+			if b.Rate <= 0 || b.Burst < 1 {
+				return nil, fmt.Errorf("%w: %+v", ErrInvalidBucket, b)
+			}
+		}
+
+		if cfg.IPv4Prefix < 0 || cfg.IPv4Prefix > ipv4Bits || cfg.IPv6Prefix < 0 || cfg.IPv6Prefix > ipv6Bits {
+			return nil, fmt.Errorf("%w: /%d, /%d", ErrInvalidPrefix, cfg.IPv4Prefix, cfg.IPv6Prefix)
+		}
+	}
+
+	return &Limiter{
+		mu:      sync.Mutex{},
+		cfg:     cfg,
+		budget:  budget,
+		buckets: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}, nil
+}
+
+// Allow takes a token for the client IP and reports whether there was one in both buckets.
+// If not, nothing is taken and it returns how long until both buckets have a token again.
+// An address that can not be parsed is its own subnet.
+func (l *Limiter) Allow(clientIP string) (bool, time.Duration) {
+	if !l.cfg.Enabled {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	ipBucket := l.bucket("ip:"+clientIP, l.budget.IP, now)
+	subnetBucket := l.bucket("subnet:"+Subnet(clientIP, l.cfg.IPv4Prefix, l.cfg.IPv6Prefix), l.budget.Subnet, now)
+
+	retryAfter := wait(ipBucket, l.budget.IP)
+	if subnetWait := wait(subnetBucket, l.budget.Subnet); subnetWait > retryAfter {
+		retryAfter = subnetWait
+	}
+
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+
+	ipBucket.tokens--
+	subnetBucket.tokens--
+
+	return true, 0
+}
+
+// Subnet returns the subnet of the IP in CIDR notation, the IP itself if it can not be parsed.
+func Subnet(ip string, ipv4Prefix int, ipv6Prefix int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+
+	if v4 := parsed.To4(); v4 != nil {
+		return v4.Mask(net.CIDRMask(ipv4Prefix, ipv4Bits)).String() + "/" + strconv.Itoa(ipv4Prefix)
+	}
+
+	return parsed.Mask(net.CIDRMask(ipv6Prefix, ipv6Bits)).String() + "/" + strconv.Itoa(ipv6Prefix)
+}
+
+// bucket returns the bucket of key refilled up to now, a full one if it is new.
+func (l *Limiter) bucket(key string, cfg config.Bucket, now time.Time) *bucket {
+	if elem, ok := l.buckets[key]; ok {
+		l.order.MoveToBack(elem)
+
+		b := elem.Value.(*bucket)
+		b.tokens = math.Min(float64(cfg.Burst), b.tokens+now.Sub(b.updated).Seconds()*cfg.Rate)
+		b.updated = now
+
+		return b
+	}
+
+	for l.cfg.MaxClients > 0 && l.order.Len() >= l.cfg.MaxClients {
+		l.evict(now)
+	}
+
+	b := &bucket{key: key, cfg: cfg, tokens: float64(cfg.Burst), updated: now}
+	l.buckets[key] = l.order.PushBack(b)
+
+	return b
+}
+
+// evict drops a bucket that has refilled, as a new one would be, from the least recently used ones.
+// If they are all still in use the least recently used is dropped anyway and its client starts over
+// with a full bucket: with more active clients than MaxClients, rotating addresses resets the limit.
+func (l *Limiter) evict(now time.Time) {
+	victim := l.order.Front()
+
+	elem := victim
+	for i := 0; i < evictScan && elem != nil; i++ {
+		b := elem.Value.(*bucket)
+		if b.tokens+now.Sub(b.updated).Seconds()*b.cfg.Rate >= float64(b.cfg.Burst) {
+			victim = elem
+
+			break
+		}
+
+		elem = elem.Next()
+	}
+
+	l.order.Remove(victim)
+	delete(l.buckets, victim.Value.(*bucket).key)
+}
+
+// wait returns how long until the bucket has a token, 0 if it has one now.
+func wait(b *bucket, cfg config.Bucket) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / cfg.Rate * float64(time.Second))
+}
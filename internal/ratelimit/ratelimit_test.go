@@ -0,0 +1,157 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(maxClients int) config.RateLimit {
+	return config.RateLimit{
+		Enabled:     true,
+		IPv4Prefix:  24,
+		IPv6Prefix:  64,
+		MaxClients:  maxClients,
+		Connections: config.Budget{IP: config.Bucket{Rate: 1, Burst: 2}, Subnet: config.Bucket{Rate: 1, Burst: 3}},
+		Challenges:  config.Budget{IP: config.Bucket{Rate: 0, Burst: 0}, Subnet: config.Bucket{Rate: 0, Burst: 0}},
+	}
+}
+
+func newTestLimiter(t *testing.T, maxClients int) (*Limiter, *time.Time) {
+	t.Helper()
+
+	cfg := testConfig(maxClients)
+
+	limiter, err := New(cfg, cfg.Connections)
+	require.NoError(t, err)
+
+	now := time.Unix(1625075186, 0)
+	limiter.now = func() time.Time { return now }
+
+	return limiter, &now
+}
+
+func TestAllow(t *testing.T) {
+	t.Parallel()
+
+	limiter, now := newTestLimiter(t, 100)
+
+	// the bucket of the IP holds a burst of two
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.Allow("203.0.113.7")
+		assert.True(t, ok)
+	}
+
+	ok, retryAfter := limiter.Allow("203.0.113.7")
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, retryAfter)
+
+	// another IP of the subnet takes the last token of the subnet
+	ok, _ = limiter.Allow("203.0.113.8")
+	assert.True(t, ok)
+
+	ok, _ = limiter.Allow("203.0.113.9")
+	assert.False(t, ok)
+
+	// other subnets are not limited
+	ok, _ = limiter.Allow("198.51.100.7")
+	assert.True(t, ok)
+
+	// tokens come back at the rate
+	*now = now.Add(time.Second)
+
+	ok, _ = limiter.Allow("203.0.113.7")
+	assert.True(t, ok)
+
+	ok, _ = limiter.Allow("203.0.113.7")
+	assert.False(t, ok)
+}
+
+func TestAllowEviction(t *testing.T) {
+	t.Parallel()
+
+	limiter, _ := newTestLimiter(t, 2)
+
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.Allow("2001:db8::1")
+		assert.True(t, ok)
+	}
+
+	ok, _ := limiter.Allow("2001:db8::1")
+	assert.False(t, ok)
+
+	// the buckets of the least recently used client are dropped
+	ok, _ = limiter.Allow("198.51.100.7")
+	assert.True(t, ok)
+	assert.Len(t, limiter.buckets, 2)
+
+	ok, _ = limiter.Allow("2001:db8::1")
+	assert.True(t, ok)
+}
+
+func TestAllowEvictsFullBuckets(t *testing.T) {
+	t.Parallel()
+
+	limiter, now := newTestLimiter(t, 4)
+
+	// the first client is used up, the second used once and refilled a second later
+	for i := 0; i < 2; i++ {
+		ok, _ := limiter.Allow("203.0.113.7")
+		assert.True(t, ok)
+	}
+
+	ok, _ := limiter.Allow("198.51.100.7")
+	assert.True(t, ok)
+
+	*now = now.Add(time.Second)
+
+	// the full buckets of the second client are dropped though the first client was used before
+	ok, _ = limiter.Allow("192.0.2.7")
+	assert.True(t, ok)
+	assert.Len(t, limiter.buckets, 4)
+	assert.Contains(t, limiter.buckets, "ip:203.0.113.7")
+	assert.NotContains(t, limiter.buckets, "ip:198.51.100.7")
+}
+
+func TestAllowDisabled(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig(1)
+	cfg.Enabled = false
+
+	limiter, err := New(cfg, cfg.Challenges)
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		ok, _ := limiter.Allow("203.0.113.7")
+		assert.True(t, ok)
+	}
+}
+
+func TestNewLimitsInvalid(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{}
+	cfg.TCP.RateLimit = testConfig(1)
+
+	_, err := NewLimits(cfg)
+	assert.ErrorIs(t, err, ErrInvalidBucket)
+
+	cfg.TCP.RateLimit.Challenges = cfg.TCP.RateLimit.Connections
+	cfg.TCP.RateLimit.IPv4Prefix = 33
+
+	_, err = NewLimits(cfg)
+	assert.ErrorIs(t, err, ErrInvalidPrefix)
+}
+
+func TestSubnet(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, "203.0.113.0/24", Subnet("203.0.113.7", 24, 64))
+	assert.Equal(t, "203.0.113.0/24", Subnet("::ffff:203.0.113.7", 24, 64))
+	assert.Equal(t, "2001:db8:0:1::/64", Subnet("2001:db8:0:1:aaaa::1", 24, 64))
+	assert.Equal(t, "not-an-ip", Subnet("not-an-ip", 24, 64))
+}
@@ -3,6 +3,7 @@ package redisdb
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"time"
 
 	"zenquote/internal/config"
@@ -10,6 +11,52 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// addMemberScript removes the expired members of the sorted set and adds the new member
+// unless the set is full. The set expires with its latest member.
+var addMemberScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[4])
+redis.call('PEXPIREAT', KEYS[1], ARGV[2])
+return 1
+`)
+
+// decrCounterScript decrements the counter if it exists and is positive. Negative counters
+// are unlimited and left as they are. Returns whether the counter was taken and its value.
+var decrCounterScript = redis.NewScript(`
+local value = tonumber(redis.call('GET', KEYS[1]))
+if not value or value == 0 then
+	return {0, 0}
+end
+if value < 0 then
+	return {1, value}
+end
+return {1, redis.call('DECR', KEYS[1])}
+`)
+
+// incrCounterScript gives one back to the counter if it exists and is not unlimited.
+// Returns whether the counter was given back to.
+var incrCounterScript = redis.NewScript(`
+local value = tonumber(redis.call('GET', KEYS[1]))
+if not value or value < 0 then
+	return 0
+end
+redis.call('INCR', KEYS[1])
+return 1
+`)
+
+// incrFieldScript increments the hash field and sets the key TTL if the key has none, so the
+// counters of a window reset when it ends however often they are incremented within it.
+var incrFieldScript = redis.NewScript(`
+redis.call('HINCRBY', KEYS[1], ARGV[1], 1)
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 1
+`)
+
 type RedisStorage struct {
 	rdb *redis.Client
 }
@@ -32,6 +79,16 @@ func (r *RedisStorage) Store(ctx context.Context, key string, value string, ttl
 	return nil
 }
 
+// StoreIfAbsent sets the key only if it does not exist yet and reports whether it has been set.
+func (r *RedisStorage) StoreIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	ok, err := r.rdb.SetNX(ctx, key, value, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("set key value if absent failed: %w", err)
+	}
+
+	return ok, nil
+}
+
 func (r *RedisStorage) Get(ctx context.Context, key string) (string, error) {
 	val, err := r.rdb.Get(ctx, key).Result()
 	if err != nil {
@@ -48,3 +105,116 @@ func (r *RedisStorage) Delete(ctx context.Context, key string) error {
 
 	return nil
 }
+
+// IncrField increments the hash field by one. The TTL is set when the key is created
+// and is not extended by later increments.
+func (r *RedisStorage) IncrField(ctx context.Context, key string, field string, ttl time.Duration) error {
+	if err := incrFieldScript.Run(ctx, r.rdb, []string{key}, field, ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("incr field failed: %w", err)
+	}
+
+	return nil
+}
+
+// GetFields returns all integer hash fields of the key, an empty map if the key does not exist.
+func (r *RedisStorage) GetFields(ctx context.Context, key string) (map[string]int64, error) {
+	vals, err := r.rdb.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("get fields failed: %w", err)
+	}
+
+	fields := make(map[string]int64, len(vals))
+
+	for field, val := range vals {
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse field %s failed: %w", field, err)
+		}
+
+		fields[field] = n
+	}
+
+	return fields, nil
+}
+
+// AddMember adds the member to the sorted set of key until expiresAt, unless the set already holds
+// limit unexpired members, and reports whether it has been added.
+func (r *RedisStorage) AddMember(
+	ctx context.Context,
+	key string,
+	member string,
+	expiresAt time.Time,
+	limit int,
+) (bool, error) {
+	added, err := addMemberScript.Run(ctx, r.rdb, []string{key},
+		time.Now().UnixMilli(), expiresAt.UnixMilli(), limit, member).Int()
+	if err != nil {
+		return false, fmt.Errorf("add member failed: %w", err)
+	}
+
+	return added == 1, nil
+}
+
+// RemoveMember removes the member from the sorted set of key.
+func (r *RedisStorage) RemoveMember(ctx context.Context, key string, member string) error {
+	if err := r.rdb.ZRem(ctx, key, member).Err(); err != nil {
+		return fmt.Errorf("remove member failed: %w", err)
+	}
+
+	return nil
+}
+
+// DecrCounter takes one from the counter of key and returns what is left. It reports false if the counter
+// is missing or at zero, a negative counter is unlimited and returned unchanged.
+func (r *RedisStorage) DecrCounter(ctx context.Context, key string) (int64, bool, error) {
+	result, err := decrCounterScript.Run(ctx, r.rdb, []string{key}).Int64Slice()
+	if err != nil {
+		return 0, false, fmt.Errorf("decrement counter failed: %w", err)
+	}
+
+	return result[1], result[0] == 1, nil
+}
+
+// IncrCounter gives one back to the counter of key, undoing a DecrCounter. Missing counters stay missing
+// and negative ones unlimited, the TTL of the counter is kept.
+func (r *RedisStorage) IncrCounter(ctx context.Context, key string) error {
+	if err := incrCounterScript.Run(ctx, r.rdb, []string{key}).Err(); err != nil {
+		return fmt.Errorf("increment counter failed: %w", err)
+	}
+
+	return nil
+}
+
+// AddToSet adds the member to the set of key. If the set grows beyond maxSize,
+// random members are evicted.
+func (r *RedisStorage) AddToSet(ctx context.Context, key string, member string, maxSize int64) error {
+	var card *redis.IntCmd
+
+	_, err := r.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, key, member)
+		card = pipe.SCard(ctx, key)
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("add to set failed: %w", err)
+	}
+
+	if extra := card.Val() - maxSize; extra > 0 {
+		if err = r.rdb.SPopN(ctx, key, extra).Err(); err != nil {
+			return fmt.Errorf("evict from set failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RandomFromSet returns a random member of the set of key, redis.Nil if the set is empty.
+func (r *RedisStorage) RandomFromSet(ctx context.Context, key string) (string, error) {
+	member, err := r.rdb.SRandMember(ctx, key).Result()
+	if err != nil {
+		return "", fmt.Errorf("random from set failed: %w", err)
+	}
+
+	return member, nil
+}
@@ -10,6 +10,7 @@ import (
 	"zenquote/internal/redisdb"
 
 	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/require"
 )
 
@@ -46,6 +47,16 @@ func TestRedisStorage(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, value, storedValue)
 
+	// Test StoreIfAbsent function
+	ok, err := storage.StoreIfAbsent(context.Background(), key, "othervalue", ttl)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	ok, err = storage.StoreIfAbsent(context.Background(), "otherkey", value, ttl)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, ttl, server.TTL("otherkey"))
+
 	// Test Delete function
 	err = storage.Delete(context.Background(), key)
 	require.NoError(t, err)
@@ -55,3 +66,203 @@ func TestRedisStorage(t *testing.T) {
 	require.Error(t, err)
 	require.ErrorContains(t, err, "redis: nil")
 }
+
+func TestRedisStorageFields(t *testing.T) {
+	t.Parallel()
+
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+
+	defer server.Close()
+
+	addr := strings.Split(server.Addr(), ":")
+	port, _ := strconv.Atoi(addr[1])
+	cfg := config.Config{
+		Redis: config.Redis{
+			Host: addr[0],
+			Port: uint16(port),
+		},
+	}
+	storage := redisdb.NewRedisStorage(cfg)
+
+	key := "testkey"
+	ttl := 10 * time.Second
+
+	// Missing key has no fields
+	fields, err := storage.GetFields(context.Background(), key)
+	require.NoError(t, err)
+	require.Empty(t, fields)
+
+	// Test IncrField function
+	require.NoError(t, storage.IncrField(context.Background(), key, "failed", ttl))
+	require.NoError(t, storage.IncrField(context.Background(), key, "failed", ttl))
+	require.NoError(t, storage.IncrField(context.Background(), key, "solved", ttl))
+
+	fields, err = storage.GetFields(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int64{"failed": 2, "solved": 1}, fields)
+	require.Equal(t, ttl, server.TTL(key))
+
+	// increments within a window do not extend it, the counters reset when it ends
+	for window := 0; window < 3; window++ {
+		for i := 0; i < 4; i++ {
+			server.FastForward(ttl / 4)
+			require.NoError(t, storage.IncrField(context.Background(), key, "requests", ttl))
+		}
+
+		fields, err = storage.GetFields(context.Background(), key)
+		require.NoError(t, err)
+		require.LessOrEqual(t, fields["requests"], int64(4))
+	}
+}
+
+func TestRedisStorageMembers(t *testing.T) {
+	t.Parallel()
+
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+
+	defer server.Close()
+
+	addr := strings.Split(server.Addr(), ":")
+	port, _ := strconv.Atoi(addr[1])
+	cfg := config.Config{
+		Redis: config.Redis{
+			Host: addr[0],
+			Port: uint16(port),
+		},
+	}
+	storage := redisdb.NewRedisStorage(cfg)
+
+	key := "testkey"
+	expiresAt := time.Now().Add(time.Minute)
+
+	// Test AddMember function up to the limit
+	for _, member := range []string{"a", "b"} {
+		ok, err := storage.AddMember(context.Background(), key, member, expiresAt, 2)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	ok, err := storage.AddMember(context.Background(), key, "c", expiresAt, 2)
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// Test RemoveMember function frees a slot
+	require.NoError(t, storage.RemoveMember(context.Background(), key, "a"))
+
+	ok, err = storage.AddMember(context.Background(), key, "c", expiresAt, 2)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// Expired members do not count
+	ok, err = storage.AddMember(context.Background(), "otherkey", "a", time.Now().Add(-time.Second), 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	ok, err = storage.AddMember(context.Background(), "otherkey", "b", expiresAt, 1)
+	require.NoError(t, err)
+	require.True(t, ok)
+}
+
+func TestRedisStorageSet(t *testing.T) {
+	t.Parallel()
+
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+
+	defer server.Close()
+
+	addr := strings.Split(server.Addr(), ":")
+	port, _ := strconv.Atoi(addr[1])
+	cfg := config.Config{
+		Redis: config.Redis{
+			Host: addr[0],
+			Port: uint16(port),
+		},
+	}
+	storage := redisdb.NewRedisStorage(cfg)
+
+	key := "testkey"
+
+	// Empty set has no members
+	_, err = storage.RandomFromSet(context.Background(), key)
+	require.ErrorIs(t, err, redis.Nil)
+
+	// Test AddToSet function evicts beyond the max size
+	for _, member := range []string{"a", "b", "c", "d"} {
+		require.NoError(t, storage.AddToSet(context.Background(), key, member, 3))
+	}
+
+	members, err := server.Members(key)
+	require.NoError(t, err)
+	require.Len(t, members, 3)
+
+	// Test RandomFromSet function
+	member, err := storage.RandomFromSet(context.Background(), key)
+	require.NoError(t, err)
+	require.Contains(t, members, member)
+}
+
+func TestRedisStorageCounter(t *testing.T) {
+	t.Parallel()
+
+	server, err := miniredis.Run()
+	require.NoError(t, err)
+
+	defer server.Close()
+
+	addr := strings.Split(server.Addr(), ":")
+	port, _ := strconv.Atoi(addr[1])
+	cfg := config.Config{
+		Redis: config.Redis{
+			Host: addr[0],
+			Port: uint16(port),
+		},
+	}
+	storage := redisdb.NewRedisStorage(cfg)
+
+	// Test DecrCounter function until the counter is used up
+	require.NoError(t, storage.Store(context.Background(), "counter", "2", time.Minute))
+
+	for _, expected := range []int64{1, 0} {
+		left, ok, err := storage.DecrCounter(context.Background(), "counter")
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, expected, left)
+	}
+
+	_, ok, err := storage.DecrCounter(context.Background(), "counter")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	// A counter given back to can be taken from again, with its TTL kept
+	require.NoError(t, storage.IncrCounter(context.Background(), "counter"))
+	require.Equal(t, time.Minute, server.TTL("counter"))
+
+	left, ok, err := storage.DecrCounter(context.Background(), "counter")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(0), left)
+
+	require.NoError(t, storage.IncrCounter(context.Background(), "missing"))
+	require.False(t, server.Exists("missing"))
+
+	// Missing counters can not be taken from, negative ones are unlimited
+	_, ok, err = storage.DecrCounter(context.Background(), "missing")
+	require.NoError(t, err)
+	require.False(t, ok)
+
+	require.NoError(t, storage.Store(context.Background(), "unlimited", "-1", time.Minute))
+
+	left, ok, err = storage.DecrCounter(context.Background(), "unlimited")
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, int64(-1), left)
+
+	require.NoError(t, storage.IncrCounter(context.Background(), "unlimited"))
+
+	value, err := server.Get("unlimited")
+	require.NoError(t, err)
+	require.Equal(t, "-1", value)
+}
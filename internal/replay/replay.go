@@ -0,0 +1,78 @@
+package replay
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+	"zenquote/internal/config"
+)
+
+type entry struct {
+	key     string
+	expires time.Time
+}
+
+// Cache remembers keys for a TTL so a solved challenge can be spent only once.
+// It holds at most size keys, when full the oldest key is evicted even if it has not expired yet.
+type Cache struct {
+	mu    sync.Mutex
+	size  int
+	keys  map[string]*list.Element
+	order *list.List // entries ordered by insertion
+	now   func() time.Time
+}
+
+func NewCache(cfg config.Config) *Cache {
+	return &Cache{
+		mu:    sync.Mutex{},
+		size:  cfg.Pow.Replay.CacheSize,
+		keys:  make(map[string]*list.Element),
+		order: list.New(),
+		now:   time.Now,
+	}
+}
+
+// Add remembers the key for ttl. It returns false if the key is already remembered.
+func (c *Cache) Add(key string, ttl time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	c.purge(now)
+
+	if _, ok := c.keys[key]; ok {
+		return false
+	}
+
+	for c.size > 0 && c.order.Len() >= c.size {
+		c.remove(c.order.Front())
+	}
+
+	c.keys[key] = c.order.PushBack(&entry{key: key, expires: now.Add(ttl)})
+
+	return true
+}
+
+// StoreIfAbsent remembers the key for ttl, the value is ignored.
+// It returns false if the key is already remembered.
+func (c *Cache) StoreIfAbsent(_ context.Context, key string, _ string, ttl time.Duration) (bool, error) {
+	return c.Add(key, ttl), nil
+}
+
+// purge removes expired entries from the front of the list.
+// Entries are not strictly ordered by expiry when TTLs differ, the rest expire on later calls.
+func (c *Cache) purge(now time.Time) {
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		if elem.Value.(*entry).expires.After(now) {
+			return
+		}
+
+		c.remove(elem)
+	}
+}
+
+func (c *Cache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.keys, elem.Value.(*entry).key)
+}
@@ -0,0 +1,60 @@
+package replay
+
+import (
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCache(size int) (*Cache, *time.Time) {
+	cfg := config.Config{}
+	cfg.Pow.Replay.CacheSize = size
+
+	now := time.Unix(1625075186, 0)
+	cache := NewCache(cfg)
+	cache.now = func() time.Time { return now }
+
+	return cache, &now
+}
+
+func TestAdd(t *testing.T) {
+	t.Parallel()
+
+	cache, _ := newTestCache(10)
+
+	assert.True(t, cache.Add("a", time.Minute))
+	assert.True(t, cache.Add("b", time.Minute))
+	assert.False(t, cache.Add("a", time.Minute), "Expected replayed key to be rejected")
+}
+
+func TestExpiry(t *testing.T) {
+	t.Parallel()
+
+	cache, now := newTestCache(10)
+
+	assert.True(t, cache.Add("a", time.Minute))
+
+	*now = now.Add(time.Minute - time.Second)
+	assert.False(t, cache.Add("a", time.Minute))
+
+	*now = now.Add(time.Second)
+	assert.True(t, cache.Add("a", time.Minute), "Expected expired key to be accepted again")
+	assert.Equal(t, 1, cache.order.Len())
+}
+
+func TestCapacity(t *testing.T) {
+	t.Parallel()
+
+	cache, _ := newTestCache(2)
+
+	assert.True(t, cache.Add("a", time.Minute))
+	assert.True(t, cache.Add("b", time.Minute))
+	assert.True(t, cache.Add("c", time.Minute))
+
+	assert.Equal(t, 2, cache.order.Len())
+	assert.Len(t, cache.keys, 2)
+	assert.False(t, cache.Add("c", time.Minute))
+	assert.True(t, cache.Add("a", time.Minute), "Expected the oldest key to be evicted")
+}
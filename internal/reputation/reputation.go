@@ -0,0 +1,103 @@
+package reputation
+
+import (
+	"context"
+	"time"
+	"zenquote/internal/config"
+
+	"go.uber.org/zap"
+)
+
+const keyPrefix = "reputation:"
+
+// Event is a client behaviour tracked in the reputation history.
+type Event string
+
+const (
+	EventRequest          Event = "requests"  // any request, used for request frequency
+	EventSolved           Event = "solved"    // accepted challenge solution
+	EventFailedSolution   Event = "failed"    // rejected challenge solution
+	EventMalformedRequest Event = "malformed" // request that could not be parsed or served
+	EventSessionLimit     Event = "limited"   // session request limit exceeded
+)
+
+type Repo interface {
+	IncrField(ctx context.Context, key string, field string, ttl time.Duration) error
+	GetFields(ctx context.Context, key string) (map[string]int64, error)
+}
+
+// Tracker keeps per client history and turns it into a difficulty adjustment:
+// misbehaving clients get harder challenges and well-behaved ones get easier challenges.
+type Tracker struct {
+	cfg    config.Reputation
+	logger *zap.Logger
+	repo   Repo
+}
+
+func NewTracker(cfg config.Config, logger *zap.Logger, repo Repo) *Tracker {
+	return &Tracker{cfg: cfg.Reputation, logger: logger, repo: repo}
+}
+
+// Record adds the event to the client history. Failures are logged and otherwise ignored,
+// reputation must never break serving a request.
+func (t *Tracker) Record(ctx context.Context, clientIP string, event Event) {
+	if !t.cfg.Enabled {
+		return
+	}
+
+	if err := t.repo.IncrField(ctx, keyPrefix+clientIP, string(event), t.cfg.Window); err != nil {
+		t.logger.Error("record reputation event failed",
+			zap.Error(err), zap.String("clientIP", clientIP), zap.String("event", string(event)))
+	}
+}
+
+// Bits returns the base challenge bits adjusted by the client reputation.
+func (t *Tracker) Bits(ctx context.Context, clientIP string, base int) int {
+	if !t.cfg.Enabled {
+		return base
+	}
+
+	history, err := t.repo.GetFields(ctx, keyPrefix+clientIP)
+	if err != nil {
+		t.logger.Error("get reputation failed", zap.Error(err), zap.String("clientIP", clientIP))
+
+		return base
+	}
+
+	bits := base + t.adjustment(history)
+	if bits < 0 {
+		return 0
+	}
+
+	return bits
+}
+
+// adjustment converts the history into a number of bits added to (or removed from) the base difficulty.
+func (t *Tracker) adjustment(history map[string]int64) int {
+	penalty := history[string(EventFailedSolution)]*t.cfg.FailedPoints +
+		history[string(EventMalformedRequest)]*t.cfg.MalformedPoints +
+		history[string(EventSessionLimit)]*t.cfg.LimitPoints
+
+	if excess := history[string(EventRequest)] - t.cfg.FreeRequests; t.cfg.FreeRequests > 0 && excess > 0 {
+		penalty += excess * t.cfg.RequestPoints
+	}
+
+	if penalty == 0 {
+		if t.cfg.TrustedSolved > 0 && history[string(EventSolved)] >= t.cfg.TrustedSolved {
+			return -t.cfg.BonusBits
+		}
+
+		return 0
+	}
+
+	if t.cfg.PointsPerBit <= 0 {
+		return 0
+	}
+
+	penaltyBits := int(penalty / t.cfg.PointsPerBit)
+	if penaltyBits > t.cfg.MaxPenaltyBits {
+		return t.cfg.MaxPenaltyBits
+	}
+
+	return penaltyBits
+}
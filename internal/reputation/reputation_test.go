@@ -0,0 +1,126 @@
+package reputation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+type MockRepo struct {
+	IncrFieldFunc func(ctx context.Context, key string, field string, ttl time.Duration) error
+	GetFieldsFunc func(ctx context.Context, key string) (map[string]int64, error)
+}
+
+func (mr *MockRepo) IncrField(ctx context.Context, key string, field string, ttl time.Duration) error {
+	if mr.IncrFieldFunc != nil {
+		return mr.IncrFieldFunc(ctx, key, field, ttl)
+	}
+
+	return nil
+}
+
+func (mr *MockRepo) GetFields(ctx context.Context, key string) (map[string]int64, error) {
+	if mr.GetFieldsFunc != nil {
+		return mr.GetFieldsFunc(ctx, key)
+	}
+
+	return map[string]int64{}, nil
+}
+
+func testConfig() config.Config {
+	return config.Config{
+		Reputation: config.Reputation{
+			Enabled:         true,
+			Window:          time.Hour,
+			FailedPoints:    4,
+			MalformedPoints: 2,
+			LimitPoints:     1,
+			FreeRequests:    10,
+			RequestPoints:   1,
+			PointsPerBit:    4,
+			MaxPenaltyBits:  3,
+			TrustedSolved:   5,
+			BonusBits:       2,
+		},
+	}
+}
+
+func TestBits(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		history  map[string]int64
+		expected int
+	}{
+		{name: "new client", history: map[string]int64{}, expected: 10},
+		{name: "failed solution", history: map[string]int64{"failed": 1}, expected: 11},
+		{name: "malformed and limited", history: map[string]int64{"malformed": 1, "limited": 2}, expected: 11},
+		{name: "frequent requests", history: map[string]int64{"requests": 18}, expected: 12},
+		{name: "penalty cap", history: map[string]int64{"failed": 100}, expected: 13},
+		{name: "trusted client", history: map[string]int64{"solved": 5, "requests": 10}, expected: 8},
+		{name: "untrusted solver", history: map[string]int64{"solved": 5, "failed": 1}, expected: 11},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			repo := &MockRepo{
+				IncrFieldFunc: nil,
+				GetFieldsFunc: func(ctx context.Context, key string) (map[string]int64, error) {
+					assert.Equal(t, "reputation:127.0.0.1", key)
+
+					return tcCopy.history, nil
+				},
+			}
+			tracker := NewTracker(testConfig(), zap.NewNop(), repo)
+
+			assert.Equal(t, tcCopy.expected, tracker.Bits(context.Background(), "127.0.0.1", 10))
+		})
+	}
+}
+
+func TestBitsRepoError(t *testing.T) {
+	t.Parallel()
+
+	repo := &MockRepo{
+		IncrFieldFunc: nil,
+		GetFieldsFunc: func(ctx context.Context, key string) (map[string]int64, error) {
+			return nil, errors.New("connection refused")
+		},
+	}
+	tracker := NewTracker(testConfig(), zap.NewNop(), repo)
+
+	assert.Equal(t, 10, tracker.Bits(context.Background(), "127.0.0.1", 10))
+}
+
+func TestDisabled(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig()
+	cfg.Reputation.Enabled = false
+
+	repo := &MockRepo{
+		IncrFieldFunc: func(ctx context.Context, key string, field string, ttl time.Duration) error {
+			t.Error("IncrField must not be called when reputation is disabled")
+
+			return nil
+		},
+		GetFieldsFunc: func(ctx context.Context, key string) (map[string]int64, error) {
+			t.Error("GetFields must not be called when reputation is disabled")
+
+			return nil, nil
+		},
+	}
+	tracker := NewTracker(cfg, zap.NewNop(), repo)
+
+	tracker.Record(context.Background(), "127.0.0.1", EventFailedSolution)
+	assert.Equal(t, 10, tracker.Bits(context.Background(), "127.0.0.1", 10))
+}
@@ -2,10 +2,18 @@ package tcp
 
 import (
 	"context"
-	"io"
+	"errors"
+	"math"
 	"time"
 	"zenquote/api"
+	"zenquote/internal/config"
+	"zenquote/internal/credit"
+	"zenquote/internal/difficulty"
+	"zenquote/internal/framing"
 	"zenquote/internal/pow"
+	"zenquote/internal/quote"
+	"zenquote/internal/ratelimit"
+	"zenquote/internal/reputation"
 
 	"google.golang.org/protobuf/proto"
 
@@ -13,7 +21,12 @@ import (
 )
 
 const (
-	hashcashStoreTTL = 30 * time.Minute // TTL for the hashcash data in the repository
+	spentKeyPrefix       = "spent:"       // key prefix of solved challenges in the spent repository
+	challengeKeyPrefix   = "challenge:"   // key prefix of issued challenges by challenge id
+	outstandingKeyPrefix = "outstanding:" // key prefix of the unsolved challenge ids of a client IP
+
+	ProtocolVersion    = 1 // highest protocol version negotiated with HELLO
+	minProtocolVersion = 1
 )
 
 type HashcashRepo interface {
@@ -22,133 +35,620 @@ type HashcashRepo interface {
 	Delete(ctx context.Context, resource string) error
 }
 
+// SpentRepo remembers solved challenges so each one can be spent only once.
+type SpentRepo interface {
+	StoreIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (bool, error)
+}
+
+// OutstandingRepo tracks the unsolved challenges of each client IP to cap their number.
+type OutstandingRepo interface {
+	AddMember(ctx context.Context, key string, member string, expiresAt time.Time, limit int) (bool, error)
+	RemoveMember(ctx context.Context, key string, member string) error
+}
+
+// ZenquoteRepo serves the quotes of solved challenges. Repos implementing quote.Selector
+// also serve the quotes asked for with a quote filter.
 type ZenquoteRepo interface {
-	GetRandom(ctx context.Context) (string, error)
+	GetRandom(ctx context.Context) (quote.Quote, error)
 }
 
 type Handler struct {
+	cfg          config.Pow
 	logger       *zap.Logger
+	challengers  pow.Challengers
+	verifier     *pow.Verifier
+	signer       *pow.Signer
+	spent        SpentRepo
+	difficulty   *difficulty.Controller
+	reputation   *reputation.Tracker
+	limits       *ratelimit.Limits
+	credits      *credit.Ledger
 	repo         HashcashRepo
+	outstanding  OutstandingRepo
 	zenquoteRepo ZenquoteRepo
 }
 
-func NewHandler(logger *zap.Logger, store HashcashRepo, zenquoteRepo ZenquoteRepo) *Handler {
-	return &Handler{logger: logger, repo: store, zenquoteRepo: zenquoteRepo}
+func NewHandler(
+	cfg config.Config,
+	logger *zap.Logger,
+	challengers pow.Challengers,
+	verifier *pow.Verifier,
+	signer *pow.Signer,
+	spent SpentRepo,
+	difficulty *difficulty.Controller,
+	reputation *reputation.Tracker,
+	limits *ratelimit.Limits,
+	credits *credit.Ledger,
+	store HashcashRepo,
+	outstanding OutstandingRepo,
+	zenquoteRepo ZenquoteRepo,
+) *Handler {
+	return &Handler{
+		cfg:          cfg.Pow,
+		logger:       logger,
+		challengers:  challengers,
+		verifier:     verifier,
+		signer:       signer,
+		spent:        spent,
+		difficulty:   difficulty,
+		reputation:   reputation,
+		limits:       limits,
+		credits:      credits,
+		repo:         store,
+		outstanding:  outstanding,
+		zenquoteRepo: zenquoteRepo,
+	}
 }
 
-func (h *Handler) Handle(ctx context.Context, respWriter io.Writer, req *Request) {
+func (h *Handler) Handle(ctx context.Context, respWriter framing.Writer, req *Request) {
+	// a limited challenge request is turned away before anything is written to Redis, reputation included
+	if req.GetCmd() == api.Command_GET_CHALLENGE && !h.allowChallenge(respWriter, req) {
+		return
+	}
+
+	h.reputation.Record(ctx, req.ClientIP, reputation.EventRequest)
+
 	switch req.GetCmd() {
 	case api.Command_GET_CHALLENGE:
 		h.handleGetChallenge(ctx, respWriter, req)
 	case api.Command_CHECK_SOLUTION:
 		h.handleCheckSolution(ctx, respWriter, req)
+	case api.Command_HELLO:
+		h.handleHello(ctx, respWriter, req)
+	case api.Command_GET_QUOTE:
+		h.handleGetQuote(ctx, respWriter, req)
 	default:
-		h.respondWithErr(respWriter, "unknown command", zap.String("cmd", req.GetCmd().String()))
+		h.reputation.Record(ctx, req.ClientIP, reputation.EventMalformedRequest)
+		h.respondWithErr(respWriter, api.Response_BAD_REQUEST, "unknown command", zap.String("cmd", req.GetCmd().String()))
 	}
 }
 
+// Negotiate the protocol version, pow algorithm, framing and compression of the session.
+// Clients list what they support in preference order. The pow algorithm is picked by the server
+// preference among the algorithms at least as costly as the default, framing and compression by the client preference.
+func (h *Handler) handleHello(ctx context.Context, respWriter framing.Writer, req *Request) {
+	hello := req.GetHello()
+	if hello == nil || req.Session == nil || req.Session.negotiated {
+		h.reputation.Record(ctx, req.ClientIP, reputation.EventMalformedRequest)
+		h.respondWithErr(respWriter, api.Response_BAD_REQUEST, "hello missing or repeated", zap.Any("req", req))
+
+		return
+	}
+
+	version := hello.GetProtocolVersion()
+	if version < minProtocolVersion {
+		h.respondWithErr(respWriter, api.Response_UNSUPPORTED, "protocol version not supported",
+			zap.Uint32("version", version))
+
+		return
+	}
+
+	if version > ProtocolVersion {
+		version = ProtocolVersion
+	}
+
+	challenger, ok := h.challengers.Negotiate(hello.GetPowAlgorithms())
+	if !ok {
+		h.respondWithErr(respWriter, api.Response_UNSUPPORTED, "no supported pow algorithm",
+			zap.Strings("offered", hello.GetPowAlgorithms()), zap.Strings("supported", h.challengers.Algorithms()))
+
+		return
+	}
+
+	chosen := &api.Hello{
+		ProtocolVersion: version,
+		PowAlgorithms:   []string{challenger.Algorithm()},
+		Framings:        []string{negotiate(hello.GetFramings(), framing.Framings(), req.Session.Framing)},
+		Compressions:    []string{framing.Identity},
+	}
+
+	// compressed frames may contain any bytes, they need the length-prefixed framing
+	if chosen.GetFramings()[0] == framing.LengthPrefixed {
+		chosen.Compressions[0] = negotiate(hello.GetCompressions(), framing.Compressions(), framing.Identity)
+	}
+
+	h.respondWith(respWriter, &api.Response{
+		Status:       api.Response_SUCCESS,
+		Response:     nil,
+		Code:         api.Response_NO_ERROR,
+		Hello:        chosen,
+		ChallengeId:  "",
+		Quote:        nil,
+		Filtered:     false,
+		Credit:       nil,
+		RetryAfterMs: 0,
+	})
+
+	req.Session.Algorithm = challenger.Algorithm()
+	req.Session.Framing = chosen.GetFramings()[0]
+	req.Session.Compression = chosen.GetCompressions()[0]
+	req.Session.negotiated = true
+}
+
+// negotiate returns the first offered value that is supported, fallback if there is none.
+func negotiate(offered []string, supported []string, fallback string) string {
+	for _, value := range offered {
+		for _, s := range supported {
+			if value == s {
+				return value
+			}
+		}
+	}
+
+	return fallback
+}
+
+// challenger returns the challenger negotiated for the session, the default one otherwise.
+func (h *Handler) challenger(req *Request) pow.Challenger {
+	if req.Session != nil {
+		if challenger := h.challengers.Get(req.Session.Algorithm); challenger != nil {
+			return challenger
+		}
+	}
+
+	return h.challengers.Default()
+}
+
 // Generate a Proof of Work challenge.
-func (h *Handler) handleGetChallenge(ctx context.Context, respWriter io.Writer, req *Request) {
-	hashcash, err := pow.NewHashcash(req.ClientIP)
+// allowChallenge takes a token of the challenge rate limit of the client, a limited client is answered
+// with RATE_LIMITED.
+func (h *Handler) allowChallenge(respWriter framing.Writer, req *Request) bool {
+	ok, retryAfter := h.limits.Challenges.Allow(req.ClientIP)
+	if !ok {
+		h.logger.Debug("challenge rate limited", zap.String("clientIP", req.ClientIP), zap.Duration("retryAfter", retryAfter))
+		h.respondWith(respWriter,
+			retryLaterResponse(api.Response_RATE_LIMITED, "too many challenges, retry later", retryAfter))
+	}
+
+	return ok
+}
+
+func (h *Handler) handleGetChallenge(ctx context.Context, respWriter framing.Writer, req *Request) {
+	bits := h.reputation.Bits(ctx, req.ClientIP, h.difficulty.Bits())
+
+	puzzle, err := h.challenger(req).Issue(req.ClientIP, bits)
 	if err != nil {
-		h.respondWithErr(respWriter, "new hashcash failed", zap.String("clientIP", req.ClientIP))
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "new puzzle failed",
+			zap.Error(err), zap.String("clientIP", req.ClientIP))
 
 		return
 	}
 
-	err = h.repo.Store(ctx, req.ClientIP, hashcash.ToString(), hashcashStoreTTL)
+	if h.signer.Enabled() {
+		h.signer.Sign(puzzle.Stamp())
+		req.Session.challengeIssued(bits)
+		h.respondWithChallenge(respWriter, puzzle.ToString(), puzzle.Stamp().Fingerprint())
+
+		return
+	}
+
+	challenge := puzzle.ToString()
+	id := puzzle.Stamp().Fingerprint()
+
+	if !h.addOutstanding(ctx, respWriter, req, id) {
+		return
+	}
+
+	start := time.Now()
+	err = h.repo.Store(ctx, challengeKeyPrefix+id, challenge, h.verifier.Lifetime())
+	h.difficulty.ObserveRepoLatency(time.Since(start))
+
 	if err != nil {
-		h.respondWithErr(respWriter, "repo store failed")
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "repo store failed", zap.Error(err))
 
 		return
 	}
 
-	challenge := hashcash.ToString()
-	h.respondWithSuccess(respWriter, challenge)
+	req.Session.challengeIssued(bits)
+	h.respondWithChallenge(respWriter, challenge, id)
 }
 
-// Check solution Proof of Work challenge and return zen quote.
-func (h *Handler) handleCheckSolution(ctx context.Context, respWriter io.Writer, req *Request) {
-	// validate the request by checking for hashcash in repo
-	hcStr, err := h.repo.Get(ctx, req.ClientIP)
-	if err != nil || len(hcStr) == 0 {
-		h.respondWithErr(respWriter, "no hashcash found", zap.Error(err), zap.Any("req", req))
+// addOutstanding counts the challenge against the outstanding challenges of the client IP.
+// Responds with an error and returns false if the client IP already holds as many as allowed.
+func (h *Handler) addOutstanding(ctx context.Context, respWriter framing.Writer, req *Request, id string) bool {
+	if h.cfg.MaxOutstanding <= 0 {
+		return true
+	}
 
-		return
+	start := time.Now()
+	ok, err := h.outstanding.AddMember(ctx, outstandingKeyPrefix+req.ClientIP, id,
+		time.Now().Add(h.verifier.Lifetime()), h.cfg.MaxOutstanding)
+	h.difficulty.ObserveRepoLatency(time.Since(start))
+
+	if err != nil {
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "record outstanding challenge failed", zap.Error(err))
+
+		return false
 	}
 
-	// create Hashcash from received string
-	hashcash, err := pow.NewHashcashFromString(req.GetData())
+	if !ok {
+		h.reputation.Record(ctx, req.ClientIP, reputation.EventSessionLimit)
+		h.respondWithErr(respWriter, api.Response_TOO_MANY_CHALLENGES, "too many unsolved challenges",
+			zap.String("clientIP", req.ClientIP), zap.Int("max", h.cfg.MaxOutstanding))
+
+		return false
+	}
+
+	return true
+}
+
+// Check solution Proof of Work challenge and return zen quote.
+func (h *Handler) handleCheckSolution(ctx context.Context, respWriter framing.Writer, req *Request) {
+	// create Puzzle from received string
+	solution, err := pow.ParsePuzzle(req.GetData())
 	if err != nil {
-		h.respondWithErr(respWriter, "new hashcash from str failed", zap.Error(err), zap.Any("req", req))
+		h.reputation.Record(ctx, req.ClientIP, reputation.EventMalformedRequest)
+		h.respondWithErr(respWriter, api.Response_BAD_REQUEST, "parse puzzle failed",
+			zap.Error(err), zap.Any("req", req))
 
 		return
 	}
 
-	// validate solution
-	if !hashcash.ValidateSolution() {
-		h.respondWithErr(respWriter, "challenge solution invalid", zap.Any("req", req))
+	issued, ok := h.issuedPuzzle(ctx, respWriter, req, solution)
+	if !ok {
+		return
 	}
 
-	// remove the hashcash from the cache
-	if err = h.repo.Delete(ctx, req.ClientIP); err != nil {
-		h.logger.Error("remove hashcash from storage failed", zap.Error(err), zap.Any("req", req))
+	// validate solution against the issued challenge
+	if err = h.verifier.Verify(solution, issued, req.ClientIP); err != nil {
+		h.reputation.Record(ctx, req.ClientIP, reputation.EventFailedSolution)
+		h.respondWithErr(respWriter, verifyErrCode(err), "challenge solution invalid", zap.Error(err), zap.Any("req", req))
+
+		return
 	}
 
+	if !h.spend(ctx, respWriter, req, solution) {
+		return
+	}
+
+	h.reputation.Record(ctx, req.ClientIP, reputation.EventSolved)
+
 	// send zen quote
-	quote, err := h.zenquoteRepo.GetRandom(ctx)
+	zenQuote, filtered, err := h.quote(ctx, quoteFilter(req.GetQuoteFilter()))
 	if err != nil {
-		h.respondWithErr(respWriter, "get random zen quote failed", zap.Error(err), zap.Any("req", req))
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "get random zen quote failed",
+			zap.Error(err), zap.Any("req", req))
 
 		return
 	}
 
-	h.respondWithSuccess(respWriter, quote)
+	h.respondWithQuote(respWriter, zenQuote, filtered, h.mintCredit(ctx, req))
 }
 
-func (h *Handler) respondWithSuccess(respWriter io.Writer, msg string) {
-	response := &api.Response{
-		Status: api.Response_SUCCESS,
-		Response: &api.Response_Data{
-			Data: msg,
-		},
+// handleGetQuote serves a quote paid for with a credit minted by an earlier solved challenge.
+func (h *Handler) handleGetQuote(ctx context.Context, respWriter framing.Writer, req *Request) {
+	if !h.credits.Enabled() {
+		h.respondWithErr(respWriter, api.Response_UNSUPPORTED, "credits disabled", zap.Any("req", req))
+
+		return
 	}
 
-	data, err := proto.Marshal(response)
+	spent, err := h.credits.Spend(ctx, req.GetCredit())
 	if err != nil {
-		h.logger.Error("failed to marshal response", zap.Error(err), zap.String("msg", msg))
+		switch {
+		case errors.Is(err, credit.ErrInvalidToken):
+			h.reputation.Record(ctx, req.ClientIP, reputation.EventMalformedRequest)
+			h.respondWithErr(respWriter, api.Response_CREDIT_INVALID, "credit invalid", zap.Error(err), zap.Any("req", req))
+		case errors.Is(err, credit.ErrExpired), errors.Is(err, credit.ErrExhausted):
+			h.respondWithErr(respWriter, api.Response_CREDIT_INVALID, "credit expired or used up", zap.Error(err))
+		default:
+			h.respondWithErr(respWriter, api.Response_INTERNAL, "spend credit failed", zap.Error(err), zap.Any("req", req))
+		}
 
 		return
 	}
 
-	data = append(data, '\n')
-	if _, err := respWriter.Write(data); err != nil {
-		h.logger.Error("failed to write response", zap.Error(err), zap.String("msg", msg))
+	zenQuote, filtered, err := h.quote(ctx, quoteFilter(req.GetQuoteFilter()))
+	if err != nil {
+		// the client did not get the quote it paid for
+		if _, refundErr := h.credits.Refund(ctx, spent); refundErr != nil {
+			h.logger.Error("refund credit failed", zap.Error(refundErr), zap.String("clientIP", req.ClientIP))
+		}
+
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "get random zen quote failed",
+			zap.Error(err), zap.Any("req", req))
 
 		return
 	}
+
+	h.respondWithQuote(respWriter, zenQuote, filtered, creditResponse(spent))
 }
 
-func (h *Handler) respondWithErr(respWriter io.Writer, msg string, logData ...zap.Field) {
-	h.logger.Error(msg, logData...)
+// mintCredit returns a new credit for the solved challenge, nil if credits are disabled or minting failed:
+// the client has paid for its quote and gets it anyway.
+func (h *Handler) mintCredit(ctx context.Context, req *Request) *api.Credit {
+	if !h.credits.Enabled() {
+		return nil
+	}
+
+	minted, err := h.credits.Mint(ctx)
+	if err != nil {
+		h.logger.Error("mint credit failed", zap.Error(err), zap.String("clientIP", req.ClientIP))
+
+		return nil
+	}
+
+	return creditResponse(minted)
+}
+
+func creditResponse(c credit.Credit) *api.Credit {
+	return &api.Credit{
+		Token:     c.Token,
+		Remaining: c.Remaining,
+		ExpiresAt: c.ExpiresAt.Unix(),
+	}
+}
+
+// quote returns a quote matching the filter if the repo can select one, a random quote otherwise.
+// The returned bool reports whether the filter was applied.
+func (h *Handler) quote(ctx context.Context, filter quote.Filter) (quote.Quote, bool, error) {
+	if !filter.IsZero() {
+		if selector, ok := h.zenquoteRepo.(quote.Selector); ok {
+			zenQuote, err := selector.Select(ctx, filter)
+			if err == nil {
+				return zenQuote, true, nil
+			}
+
+			if !errors.Is(err, quote.ErrNoMatch) {
+				h.logger.Warn("select zen quote failed", zap.Error(err), zap.Any("filter", filter))
+			}
+		}
+	}
+
+	zenQuote, err := h.zenquoteRepo.GetRandom(ctx)
+
+	return zenQuote, false, err
+}
+
+func quoteFilter(filter *api.QuoteFilter) quote.Filter {
+	return quote.Filter{
+		Author:   filter.GetAuthor(),
+		Tag:      filter.GetTag(),
+		Keyword:  filter.GetKeyword(),
+		Language: filter.GetLanguage(),
+		Daily:    filter.GetDaily(),
+	}
+}
+
+// issuedPuzzle returns the challenge issued to the client, read from the repo or, for signed challenges,
+// the authenticated solution itself. Responds with an error and returns false if there is none.
+func (h *Handler) issuedPuzzle(
+	ctx context.Context,
+	respWriter framing.Writer,
+	req *Request,
+	solution pow.Puzzle,
+) (pow.Puzzle, bool) {
+	if h.signer.Enabled() {
+		if err := h.signer.Authenticate(solution.Stamp()); err != nil {
+			h.reputation.Record(ctx, req.ClientIP, reputation.EventFailedSolution)
+			h.respondWithErr(respWriter, api.Response_CHALLENGE_MISMATCH, "challenge signature invalid",
+				zap.Error(err), zap.Any("req", req))
+
+			return nil, false
+		}
+
+		return solution, true
+	}
+
+	// clients without challenge ids identify the challenge by the solution itself
+	id := req.GetChallengeId()
+	if id == "" {
+		id = solution.Stamp().Fingerprint()
+	}
+
+	// validate the request by checking for hashcash in repo
+	start := time.Now()
+	hcStr, err := h.repo.Get(ctx, challengeKeyPrefix+id)
+	h.difficulty.ObserveRepoLatency(time.Since(start))
+
+	if err != nil || len(hcStr) == 0 {
+		h.respondWithErr(respWriter, api.Response_CHALLENGE_EXPIRED, "challenge not found or expired",
+			zap.Error(err), zap.Any("req", req))
+
+		return nil, false
+	}
+
+	issued, err := pow.ParsePuzzle(hcStr)
+	if err != nil {
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "stored puzzle invalid", zap.Error(err), zap.Any("req", req))
+
+		return nil, false
+	}
+
+	return issued, true
+}
+
+// spend makes sure the solved challenge can not be used again, whether the challenge was stored
+// or signed. The challenge fingerprint is recorded in the spent repository for as long as the stamp is valid.
+// Responds with an error and returns false if the challenge has already been spent.
+func (h *Handler) spend(ctx context.Context, respWriter framing.Writer, req *Request, solution pow.Puzzle) bool {
+	start := time.Now()
+	ok, err := h.spent.StoreIfAbsent(ctx, spentKeyPrefix+solution.Stamp().Fingerprint(), req.ClientIP,
+		h.verifier.ValidFor(solution.Stamp()))
+	h.difficulty.ObserveRepoLatency(time.Since(start))
+
+	if err != nil {
+		h.respondWithErr(respWriter, api.Response_INTERNAL, "record solved challenge failed",
+			zap.Error(err), zap.Any("req", req))
+
+		return false
+	}
+
+	if !ok {
+		h.reputation.Record(ctx, req.ClientIP, reputation.EventFailedSolution)
+		h.respondWithErr(respWriter, api.Response_CHALLENGE_REPLAYED, "challenge already solved", zap.Any("req", req))
+
+		return false
+	}
 
-	response := &api.Response{
+	if h.signer.Enabled() {
+		return true
+	}
+
+	// the solution matches the issued challenge, so it has the same fingerprint
+	id := solution.Stamp().Fingerprint()
+
+	// remove the hashcash from the cache
+	if err = h.repo.Delete(ctx, challengeKeyPrefix+id); err != nil {
+		h.logger.Error("remove hashcash from storage failed", zap.Error(err), zap.Any("req", req))
+	}
+
+	if h.cfg.MaxOutstanding > 0 {
+		if err = h.outstanding.RemoveMember(ctx, outstandingKeyPrefix+req.ClientIP, id); err != nil {
+			h.logger.Error("remove outstanding challenge failed", zap.Error(err), zap.Any("req", req))
+		}
+	}
+
+	return true
+}
+
+// verifyErrCode maps a pow verification error to the error code returned to the client.
+func verifyErrCode(err error) api.Response_ErrorCode {
+	switch {
+	case errors.Is(err, pow.ErrWrongResource):
+		return api.Response_WRONG_RESOURCE
+	case errors.Is(err, pow.ErrChallengeMismatch), errors.Is(err, pow.ErrChallengeNotYetValid):
+		return api.Response_CHALLENGE_MISMATCH
+	case errors.Is(err, pow.ErrChallengeExpired):
+		return api.Response_CHALLENGE_EXPIRED
+	case errors.Is(err, pow.ErrInsufficientWork):
+		return api.Response_INSUFFICIENT_WORK
+	default:
+		return api.Response_INTERNAL
+	}
+}
+
+func (h *Handler) respondWithSuccess(respWriter framing.Writer, msg string) {
+	h.respondWith(respWriter, &api.Response{
+		Status: api.Response_SUCCESS,
+		Response: &api.Response_Data{
+			Data: msg,
+		},
+		Code:         api.Response_NO_ERROR,
+		Hello:        nil,
+		ChallengeId:  "",
+		Quote:        nil,
+		Filtered:     false,
+		Credit:       nil,
+		RetryAfterMs: 0,
+	})
+}
+
+// respondWithQuote sends the quote, its text is also sent as data for clients without Quote support.
+func (h *Handler) respondWithQuote(respWriter framing.Writer, q quote.Quote, filtered bool, balance *api.Credit) {
+	h.respondWith(respWriter, &api.Response{
+		Status: api.Response_SUCCESS,
+		Response: &api.Response_Data{
+			Data: q.Text,
+		},
+		Code:        api.Response_NO_ERROR,
+		Hello:       nil,
+		ChallengeId: "",
+		Quote: &api.Quote{
+			Id:       q.ID,
+			Text:     q.Text,
+			Author:   q.Author,
+			Source:   q.Source,
+			Tags:     q.Tags,
+			Language: q.Language,
+		},
+		Filtered:     filtered,
+		Credit:       balance,
+		RetryAfterMs: 0,
+	})
+}
+
+func (h *Handler) respondWithChallenge(respWriter framing.Writer, challenge string, id string) {
+	h.respondWith(respWriter, &api.Response{
+		Status: api.Response_SUCCESS,
+		Response: &api.Response_Data{
+			Data: challenge,
+		},
+		Code:         api.Response_NO_ERROR,
+		Hello:        nil,
+		ChallengeId:  id,
+		Quote:        nil,
+		Filtered:     false,
+		Credit:       nil,
+		RetryAfterMs: 0,
+	})
+}
+
+func (h *Handler) respondWithErr(
+	respWriter framing.Writer,
+	code api.Response_ErrorCode,
+	msg string,
+	logData ...zap.Field,
+) {
+	h.logger.Error(msg, append(logData, zap.Stringer("code", code))...)
+
+	h.respondWith(respWriter, &api.Response{
+		Status: api.Response_FAILURE,
+		Response: &api.Response_Error{
+			Error: msg,
+		},
+		Code:         code,
+		Hello:        nil,
+		ChallengeId:  "",
+		Quote:        nil,
+		Filtered:     false,
+		Credit:       nil,
+		RetryAfterMs: 0,
+	})
+}
+
+// retryLaterResponse tells the client to retry after the given delay, rounded up to milliseconds.
+func retryLaterResponse(code api.Response_ErrorCode, msg string, retryAfter time.Duration) *api.Response {
+	retryAfterMs := (retryAfter + time.Millisecond - 1) / time.Millisecond
+	if retryAfterMs > math.MaxUint32 {
+		retryAfterMs = math.MaxUint32
+	}
+
+	return &api.Response{
 		Status: api.Response_FAILURE,
 		Response: &api.Response_Error{
 			Error: msg,
 		},
+		Code:         code,
+		Hello:        nil,
+		ChallengeId:  "",
+		Quote:        nil,
+		Filtered:     false,
+		Credit:       nil,
+		RetryAfterMs: uint32(retryAfterMs),
 	}
+}
 
+func (h *Handler) respondWith(respWriter framing.Writer, response *api.Response) {
 	data, err := proto.Marshal(response)
 	if err != nil {
-		h.logger.Error("failed to marshal error response", zap.Error(err))
+		h.logger.Error("failed to marshal response", zap.Error(err), zap.Any("response", response))
 
 		return
 	}
 
-	data = append(data, '\n')
-	if _, err = respWriter.Write(data); err != nil {
-		h.logger.Error("failed to write error response", zap.Error(err))
+	if err = respWriter.WriteFrame(data); err != nil {
+		h.logger.Error("failed to write response", zap.Error(err), zap.Any("response", response))
 
 		return
 	}
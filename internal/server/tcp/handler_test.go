@@ -3,15 +3,228 @@ package tcp
 import (
 	"bytes"
 	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 	"zenquote/api"
+	"zenquote/internal/config"
+	"zenquote/internal/credit"
+	"zenquote/internal/difficulty"
+	"zenquote/internal/framing"
 	"zenquote/internal/pow"
+	"zenquote/internal/quote"
+	"zenquote/internal/ratelimit"
+	"zenquote/internal/redisdb"
+	"zenquote/internal/replay"
+	"zenquote/internal/reputation"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
+var testConfig = config.Config{
+	TCP:   config.TCP{Host: "", Port: 0, ReqTimeout: 0, MaxReqSizeBytes: 1024, MaxReqPerSession: 5},
+	Redis: config.Redis{Host: "", Port: 0},
+	Pow: config.Pow{
+		Algorithm:      "",
+		Algorithms:     []string{pow.AlgorithmScrypt},
+		Bits:           12,
+		MaxAge:         time.Minute,
+		ClockSkew:      time.Second,
+		MaxOutstanding: 0,
+		Difficulty: config.Difficulty{
+			Adaptive:        false,
+			MinBits:         0,
+			MaxBits:         0,
+			Smoothing:       0,
+			SampleInterval:  0,
+			HighConnRate:    0,
+			HighInFlight:    0,
+			HighRepoLatency: 0,
+		},
+		Scrypt:    config.Scrypt{N: 1024, R: 1, P: 1, BitsDiscount: 8},
+		Stateless: config.Stateless{Enabled: false, ActiveKey: "", Keys: nil},
+		Replay:    config.Replay{Store: "memory", CacheSize: 0},
+	},
+	Reputation: config.Reputation{
+		Enabled:         false,
+		Window:          0,
+		FailedPoints:    0,
+		MalformedPoints: 0,
+		LimitPoints:     0,
+		FreeRequests:    0,
+		RequestPoints:   0,
+		PointsPerBit:    0,
+		MaxPenaltyBits:  0,
+		TrustedSolved:   0,
+		BonusBits:       0,
+	},
+	Logger: config.Logger{Level: "", Encoding: "", Colored: false, Tags: nil},
+}
+
+var errUpstream = errors.New("upstream down")
+
+var testQuote = quote.Quote{
+	ID:       "1",
+	Text:     "some random Zen quote",
+	Author:   "Unknown",
+	Source:   "test",
+	Tags:     []string{"zen"},
+	Language: "en",
+}
+
+func newTestHandler(t *testing.T, repo HashcashRepo, zenquoteRepo ZenquoteRepo) *Handler {
+	t.Helper()
+
+	return newTestHandlerWithConfig(t, testConfig, nil, repo, zenquoteRepo)
+}
+
+func newTestHandlerWithConfig(
+	t *testing.T,
+	cfg config.Config,
+	reputationRepo reputation.Repo,
+	repo HashcashRepo,
+	zenquoteRepo ZenquoteRepo,
+) *Handler {
+	t.Helper()
+
+	ctrl, err := difficulty.NewController(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create difficulty controller: %s", err)
+	}
+
+	signer, err := pow.NewSigner(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %s", err)
+	}
+
+	verifier, err := pow.NewVerifier(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create verifier: %s", err)
+	}
+
+	challengers, err := pow.NewChallengers(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create challengers: %s", err)
+	}
+
+	tracker := reputation.NewTracker(cfg, zap.NewNop(), reputationRepo)
+
+	limits, err := ratelimit.NewLimits(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create rate limits: %s", err)
+	}
+
+	ledger, err := credit.NewLedger(cfg, newTestCreditRepo(t))
+	if err != nil {
+		t.Fatalf("Failed to create credit ledger: %s", err)
+	}
+
+	return NewHandler(cfg, zap.NewNop(), challengers, verifier, signer, replay.NewCache(cfg), ctrl, tracker, limits,
+		ledger, repo, NewMockOutstandingRepo(), zenquoteRepo)
+}
+
+type MockReputationRepo struct {
+	mu     sync.Mutex
+	fields map[string]map[string]int64
+}
+
+func NewMockReputationRepo() *MockReputationRepo {
+	return &MockReputationRepo{mu: sync.Mutex{}, fields: make(map[string]map[string]int64)}
+}
+
+func (mr *MockReputationRepo) IncrField(_ context.Context, key string, field string, _ time.Duration) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.fields[key] == nil {
+		mr.fields[key] = make(map[string]int64)
+	}
+	mr.fields[key][field]++
+
+	return nil
+}
+
+func (mr *MockReputationRepo) GetFields(_ context.Context, key string) (map[string]int64, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	fields := make(map[string]int64, len(mr.fields[key]))
+	for field, val := range mr.fields[key] {
+		fields[field] = val
+	}
+
+	return fields, nil
+}
+
+type MockOutstandingRepo struct {
+	mu      sync.Mutex
+	members map[string]map[string]time.Time
+}
+
+func NewMockOutstandingRepo() *MockOutstandingRepo {
+	return &MockOutstandingRepo{mu: sync.Mutex{}, members: make(map[string]map[string]time.Time)}
+}
+
+func (mr *MockOutstandingRepo) AddMember(
+	_ context.Context,
+	key string,
+	member string,
+	expiresAt time.Time,
+	limit int,
+) (bool, error) {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.members[key] == nil {
+		mr.members[key] = make(map[string]time.Time)
+	}
+
+	for m, exp := range mr.members[key] {
+		if exp.Before(time.Now()) {
+			delete(mr.members[key], m)
+		}
+	}
+
+	if len(mr.members[key]) >= limit {
+		return false, nil
+	}
+
+	mr.members[key][member] = expiresAt
+
+	return true, nil
+}
+
+func (mr *MockOutstandingRepo) RemoveMember(_ context.Context, key string, member string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	delete(mr.members[key], member)
+
+	return nil
+}
+
+// newTestCreditRepo returns a Redis storage for the credits backed by miniredis.
+func newTestCreditRepo(t *testing.T) *redisdb.RedisStorage {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	host, port, err := net.SplitHostPort(server.Addr())
+	require.NoError(t, err)
+
+	portNum, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return redisdb.NewRedisStorage(config.Config{Redis: config.Redis{Host: host, Port: uint16(portNum)}})
+}
+
 type MockRepo struct {
 	StoreFunc  func(ctx context.Context, key string, value string, ttl time.Duration) error
 	GetFunc    func(ctx context.Context, key string) (string, error)
@@ -43,15 +256,24 @@ func (mr *MockRepo) Delete(ctx context.Context, key string) error {
 }
 
 type MockZenquoteRepo struct {
-	GetRandomFunc func(ctx context.Context) (string, error)
+	GetRandomFunc func(ctx context.Context) (quote.Quote, error)
 }
 
-func (zr *MockZenquoteRepo) GetRandom(ctx context.Context) (string, error) {
+func (zr *MockZenquoteRepo) GetRandom(ctx context.Context) (quote.Quote, error) {
 	if zr.GetRandomFunc != nil {
 		return zr.GetRandomFunc(ctx)
 	}
 
-	return "", nil
+	return quote.Quote{ID: "", Text: "", Author: "", Source: "", Tags: nil, Language: ""}, nil
+}
+
+type MockSelectorRepo struct {
+	MockZenquoteRepo
+	SelectFunc func(ctx context.Context, filter quote.Filter) (quote.Quote, error)
+}
+
+func (zr *MockSelectorRepo) Select(ctx context.Context, filter quote.Filter) (quote.Quote, error) {
+	return zr.SelectFunc(ctx, filter)
 }
 
 func TestHandleGetChallenge(t *testing.T) {
@@ -71,7 +293,7 @@ func TestHandleGetChallenge(t *testing.T) {
 		GetRandomFunc: nil,
 	}
 
-	handler := NewHandler(nil, repo, zenRepo)
+	handler := newTestHandler(t, repo, zenRepo)
 
 	req := &Request{
 		Request: &api.Request{
@@ -80,7 +302,7 @@ func TestHandleGetChallenge(t *testing.T) {
 		},
 		ClientIP: "",
 	}
-	writer := &bytes.Buffer{}
+	writer := &frameBuffer{}
 
 	handler.Handle(context.Background(), writer, req)
 
@@ -96,6 +318,13 @@ func TestHandleGetChallenge(t *testing.T) {
 func TestHandleCheckSolutionValid(t *testing.T) {
 	t.Parallel()
 
+	hc, _ := pow.NewHashcash("127.0.0.1", testConfig.Pow.Bits)
+	issued := hc.ToString()
+
+	if err := hc.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
 	repo := &MockRepo{
 		StoreFunc: func(ctx context.Context, key string, value string, ttl time.Duration) error {
 			assert.NotEmpty(t, value)
@@ -103,31 +332,26 @@ func TestHandleCheckSolutionValid(t *testing.T) {
 			return nil
 		},
 		GetFunc: func(ctx context.Context, key string) (string, error) {
-			return "resource", nil
+			return issued, nil
 		},
 		DeleteFunc: nil,
 	}
 
 	zenRepo := &MockZenquoteRepo{
-		GetRandomFunc: func(ctx context.Context) (string, error) {
-			return "some random Zen quote", nil
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
 		},
 	}
 
-	hc, _ := pow.NewHashcash("127.0.0.1")
-	if err := hc.SolveChallenge(); err != nil {
-		t.Fatalf("Failed to solve hashcash challenge: %s", err)
-	}
-
 	validHcString := hc.ToString()
 
 	req := &Request{
 		Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: validHcString},
 		ClientIP: "127.0.0.1",
 	}
-	writer := &bytes.Buffer{}
+	writer := &frameBuffer{}
 
-	handler := NewHandler(nil, repo, zenRepo)
+	handler := newTestHandler(t, repo, zenRepo)
 	handler.handleCheckSolution(context.Background(), writer, req)
 
 	resp := &api.Response{
@@ -138,4 +362,961 @@ func TestHandleCheckSolutionValid(t *testing.T) {
 
 	assert.Equal(t, api.Response_SUCCESS, resp.Status)
 	assert.Equal(t, "some random Zen quote", resp.GetData())
+	assert.Equal(t, testQuote.Author, resp.GetQuote().GetAuthor())
+	assert.Equal(t, testQuote.Source, resp.GetQuote().GetSource())
+	assert.Equal(t, testQuote.Tags, resp.GetQuote().GetTags())
+}
+
+func TestHandleCheckSolutionForged(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "127.0.0.1"
+
+	tests := []struct {
+		name   string
+		stored bool
+		forge  func(hc *pow.Hashcash)
+		code   api.Response_ErrorCode
+	}{
+		{
+			name:   "no issued challenge",
+			stored: false,
+			forge:  func(hc *pow.Hashcash) {},
+			code:   api.Response_CHALLENGE_EXPIRED,
+		},
+		{
+			name:   "forged date",
+			stored: true,
+			forge:  func(hc *pow.Hashcash) { hc.Date = hc.Date.Add(-time.Hour) },
+			code:   api.Response_CHALLENGE_MISMATCH,
+		},
+		{
+			name:   "forged rand",
+			stored: true,
+			forge:  func(hc *pow.Hashcash) { hc.Rand++ },
+			code:   api.Response_CHALLENGE_MISMATCH,
+		},
+		{
+			name:   "lowered bits",
+			stored: true,
+			forge:  func(hc *pow.Hashcash) { hc.Bits = 0 },
+			code:   api.Response_CHALLENGE_MISMATCH,
+		},
+		{
+			name:   "forged version",
+			stored: true,
+			forge:  func(hc *pow.Hashcash) { hc.Version++ },
+			code:   api.Response_CHALLENGE_MISMATCH,
+		},
+		{
+			name:   "wrong resource",
+			stored: true,
+			forge:  func(hc *pow.Hashcash) { hc.Resource = "10.0.0.1" },
+			code:   api.Response_WRONG_RESOURCE,
+		},
+		{
+			name:   "insufficient work",
+			stored: true,
+			forge:  func(hc *pow.Hashcash) {},
+			code:   api.Response_INSUFFICIENT_WORK,
+		},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			hc, err := pow.NewHashcash(clientIP, testConfig.Pow.Bits)
+			if err != nil {
+				t.Fatalf("Failed to create hashcash: %s", err)
+			}
+
+			issued := hc.ToString()
+
+			// find a counter that does not satisfy the difficulty
+			for hc.ValidateSolution() {
+				hc.Counter++
+			}
+
+			tcCopy.forge(hc)
+
+			repo := &MockRepo{
+				StoreFunc: nil,
+				GetFunc: func(ctx context.Context, key string) (string, error) {
+					if !tcCopy.stored {
+						return "", nil
+					}
+
+					return issued, nil
+				},
+				DeleteFunc: func(ctx context.Context, key string) error {
+					t.Error("Delete must not be called for an invalid solution")
+
+					return nil
+				},
+			}
+			zenRepo := &MockZenquoteRepo{
+				GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+					t.Error("GetRandom must not be called for an invalid solution")
+
+					return testQuote, nil
+				},
+			}
+
+			req := &Request{
+				Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: hc.ToString()},
+				ClientIP: clientIP,
+			}
+			writer := &frameBuffer{}
+
+			handler := newTestHandler(t, repo, zenRepo)
+			handler.handleCheckSolution(context.Background(), writer, req)
+
+			resp := readResponse(t, writer)
+			assert.Equal(t, api.Response_FAILURE, resp.GetStatus())
+			assert.Equal(t, tcCopy.code, resp.GetCode())
+		})
+	}
+}
+
+func TestHandleCheckSolutionMalformed(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{"1:2:3:4:5:6", ""} {
+		repo := &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil}
+		handler := newTestHandler(t, repo, &MockZenquoteRepo{GetRandomFunc: nil})
+
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: data},
+			ClientIP: "127.0.0.1",
+			Session:  nil,
+		})
+
+		resp := readResponse(t, writer)
+		assert.Equal(t, api.Response_FAILURE, resp.GetStatus())
+		assert.Equal(t, api.Response_BAD_REQUEST, resp.GetCode(), "data %q", data)
+	}
+}
+
+// frameBuffer records the frames written by the handler without any framing.
+type frameBuffer struct {
+	bytes.Buffer
+}
+
+func (b *frameBuffer) WriteFrame(frame []byte) error {
+	_, err := b.Write(frame)
+
+	return err
+}
+
+func readResponse(t *testing.T, writer *frameBuffer) *api.Response {
+	t.Helper()
+
+	resp := &api.Response{
+		Status:   0,
+		Response: nil,
+		Code:     0,
+	}
+	if err := proto.Unmarshal(writer.Bytes(), resp); err != nil {
+		t.Fatalf("Failed to unmarshal response: %s", err)
+	}
+
+	return resp
+}
+
+func TestHandleReputation(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "127.0.0.1"
+
+	cfg := testConfig
+	cfg.Reputation.Enabled = true
+	cfg.Reputation.FailedPoints = 1
+	cfg.Reputation.PointsPerBit = 1
+	cfg.Reputation.MaxPenaltyBits = 2
+
+	reputationRepo := NewMockReputationRepo()
+	stored := ""
+	repo := &MockRepo{
+		StoreFunc: func(ctx context.Context, key string, value string, ttl time.Duration) error {
+			stored = value
+
+			return nil
+		},
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			return stored, nil
+		},
+		DeleteFunc: nil,
+	}
+
+	handler := newTestHandlerWithConfig(t, cfg, reputationRepo, repo, &MockZenquoteRepo{GetRandomFunc: nil})
+
+	getChallenge := func() *pow.Hashcash {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_GET_CHALLENGE, Data: ""},
+			ClientIP: clientIP,
+		})
+
+		hc, err := pow.NewHashcashFromString(readResponse(t, writer).GetData())
+		if err != nil {
+			t.Fatalf("Failed to parse challenge: %s", err)
+		}
+
+		return hc
+	}
+
+	hc := getChallenge()
+	assert.Equal(t, cfg.Pow.Bits, hc.Bits)
+
+	// every failed solution makes the next challenge harder, up to the penalty cap
+	for _, expectedBits := range []int{cfg.Pow.Bits + 1, cfg.Pow.Bits + 2, cfg.Pow.Bits + 2} {
+		hc.Rand++
+
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: hc.ToString()},
+			ClientIP: clientIP,
+		})
+		assert.Equal(t, api.Response_FAILURE, readResponse(t, writer).GetStatus())
+
+		hc = getChallenge()
+		assert.Equal(t, expectedBits, hc.Bits)
+	}
+}
+
+func TestHandleStateless(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "127.0.0.1"
+
+	cfg := testConfig
+	cfg.Pow.Stateless = config.Stateless{
+		Enabled:   true,
+		ActiveKey: "k1",
+		Keys:      map[string]string{"k1": "secret"},
+	}
+
+	// the challenge repo must not be touched at all
+	repo := &MockRepo{
+		StoreFunc: func(ctx context.Context, key string, value string, ttl time.Duration) error {
+			t.Error("Store must not be called for stateless challenges")
+
+			return nil
+		},
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			t.Error("Get must not be called for stateless challenges")
+
+			return "", nil
+		},
+		DeleteFunc: func(ctx context.Context, key string) error {
+			t.Error("Delete must not be called for stateless challenges")
+
+			return nil
+		},
+	}
+	zenRepo := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
+		},
+	}
+
+	handler := newTestHandlerWithConfig(t, cfg, nil, repo, zenRepo)
+
+	checkSolution := func(solution string) *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: solution},
+			ClientIP: clientIP,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	writer := &frameBuffer{}
+	handler.Handle(context.Background(), writer, &Request{
+		Request:  &api.Request{Cmd: api.Command_GET_CHALLENGE, Data: ""},
+		ClientIP: clientIP,
+	})
+
+	hc, err := pow.NewHashcashFromString(readResponse(t, writer).GetData())
+	if err != nil {
+		t.Fatalf("Failed to parse challenge: %s", err)
+	}
+
+	// a forged easier challenge fails authentication
+	forged := *hc
+	forged.Bits = 1
+	if err = forged.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
+	assert.Equal(t, api.Response_CHALLENGE_MISMATCH, checkSolution(forged.ToString()).GetCode())
+
+	// the issued challenge is accepted once
+	if err = hc.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
+	resp := checkSolution(hc.ToString())
+	assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+	assert.Equal(t, "some random Zen quote", resp.GetData())
+
+	// and rejected when replayed, even with another solution of the same challenge
+	assert.Equal(t, api.Response_CHALLENGE_REPLAYED, checkSolution(hc.ToString()).GetCode())
+
+	hc.Counter++
+	if err = hc.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
+	assert.Equal(t, api.Response_CHALLENGE_REPLAYED, checkSolution(hc.ToString()).GetCode())
+}
+
+func TestHandleReplay(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "127.0.0.1"
+
+	hc, _ := pow.NewHashcash(clientIP, testConfig.Pow.Bits)
+	issued := hc.ToString()
+
+	if err := hc.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
+	// the challenge record is always there, as if it was recreated after every solution
+	repo := &MockRepo{
+		StoreFunc: nil,
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			return issued, nil
+		},
+		DeleteFunc: nil,
+	}
+	zenRepo := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
+		},
+	}
+
+	handler := newTestHandler(t, repo, zenRepo)
+
+	checkSolution := func() *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: hc.ToString()},
+			ClientIP: clientIP,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	assert.Equal(t, api.Response_SUCCESS, checkSolution().GetStatus())
+
+	resp := checkSolution()
+	assert.Equal(t, api.Response_FAILURE, resp.GetStatus())
+	assert.Equal(t, api.Response_CHALLENGE_REPLAYED, resp.GetCode())
+}
+
+// MockSpentRepo records the TTL of the spent challenges.
+type MockSpentRepo struct {
+	*replay.Cache
+	ttls []time.Duration
+}
+
+func (mr *MockSpentRepo) StoreIfAbsent(ctx context.Context, key string, value string, ttl time.Duration) (bool, error) {
+	mr.ttls = append(mr.ttls, ttl)
+
+	return mr.Cache.StoreIfAbsent(ctx, key, value, ttl)
+}
+
+func TestHandleReplayFutureDated(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "127.0.0.1"
+
+	// dated ahead within the clock skew, the stamp is valid for longer than the challenge lifetime
+	hc, _ := pow.NewHashcash(clientIP, testConfig.Pow.Bits)
+	hc.Date = time.Now().Truncate(time.Second).Add(testConfig.Pow.ClockSkew)
+	issued := hc.ToString()
+
+	if err := hc.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
+	repo := &MockRepo{
+		StoreFunc: nil,
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			return issued, nil
+		},
+		DeleteFunc: nil,
+	}
+	zenRepo := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
+		},
+	}
+
+	handler := newTestHandler(t, repo, zenRepo)
+	spent := &MockSpentRepo{Cache: replay.NewCache(testConfig), ttls: nil}
+	handler.spent = spent
+
+	checkSolution := func() *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: hc.ToString()},
+			ClientIP: clientIP,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	assert.Equal(t, api.Response_SUCCESS, checkSolution().GetStatus())
+	assert.Equal(t, api.Response_CHALLENGE_REPLAYED, checkSolution().GetCode())
+
+	// the spent record outlives the stamp
+	require.NotEmpty(t, spent.ttls)
+	assert.GreaterOrEqual(t, spent.ttls[0], time.Until(hc.Date.Add(handler.verifier.Lifetime())))
+	assert.Greater(t, spent.ttls[0], handler.verifier.Lifetime())
+}
+
+func TestHandleCheckSolutionExpired(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "127.0.0.1"
+
+	// a stale precomputed solution of a challenge still present in the repo
+	hc, _ := pow.NewHashcash(clientIP, testConfig.Pow.Bits)
+	hc.Date = hc.Date.Add(-testConfig.Pow.MaxAge - 2*testConfig.Pow.ClockSkew)
+	issued := hc.ToString()
+
+	if err := hc.SolveChallenge(); err != nil {
+		t.Fatalf("Failed to solve hashcash challenge: %s", err)
+	}
+
+	repo := &MockRepo{
+		StoreFunc: nil,
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			return issued, nil
+		},
+		DeleteFunc: nil,
+	}
+
+	writer := &frameBuffer{}
+	handler := newTestHandler(t, repo, &MockZenquoteRepo{GetRandomFunc: nil})
+	handler.handleCheckSolution(context.Background(), writer, &Request{
+		Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: hc.ToString()},
+		ClientIP: clientIP,
+	})
+
+	resp := readResponse(t, writer)
+	assert.Equal(t, api.Response_FAILURE, resp.GetStatus())
+	assert.Equal(t, api.Response_CHALLENGE_EXPIRED, resp.GetCode())
+}
+
+func TestHandleHello(t *testing.T) {
+	t.Parallel()
+
+	hello := func(handler *Handler, session *Session, offer *api.Hello) *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_HELLO, Data: "", Hello: offer},
+			ClientIP: "127.0.0.1",
+			Session:  session,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	newSession := func() *Session {
+		return &Session{Algorithm: "", Framing: framing.Newline, Compression: framing.Identity, negotiated: false}
+	}
+
+	t.Run("negotiated", func(t *testing.T) {
+		t.Parallel()
+
+		handler := newTestHandler(t, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil}, &MockZenquoteRepo{GetRandomFunc: nil})
+		session := newSession()
+
+		resp := hello(handler, session, &api.Hello{
+			ProtocolVersion: ProtocolVersion + 1,
+			PowAlgorithms:   []string{pow.AlgorithmScrypt, "md5"},
+			Framings:        []string{"xml", framing.LengthPrefixed},
+			Compressions:    []string{framing.Gzip, framing.Identity},
+		})
+
+		assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+		assert.Equal(t, uint32(ProtocolVersion), resp.GetHello().GetProtocolVersion())
+		assert.Equal(t, []string{pow.AlgorithmScrypt}, resp.GetHello().GetPowAlgorithms())
+		assert.Equal(t, []string{framing.LengthPrefixed}, resp.GetHello().GetFramings())
+		assert.Equal(t, []string{framing.Gzip}, resp.GetHello().GetCompressions())
+		assert.Equal(t, &Session{
+			Algorithm:   pow.AlgorithmScrypt,
+			Framing:     framing.LengthPrefixed,
+			Compression: framing.Gzip,
+			negotiated:  true,
+		}, session)
+
+		// challenges of the session use the negotiated algorithm
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil},
+			ClientIP: "127.0.0.1",
+			Session:  session,
+		})
+
+		puzzle, err := pow.ParsePuzzle(readResponse(t, writer).GetData())
+		assert.NoError(t, err)
+		assert.Equal(t, pow.AlgorithmScrypt, puzzle.Stamp().Algorithm())
+
+		// a session is negotiated once
+		resp = hello(handler, session, &api.Hello{ProtocolVersion: ProtocolVersion})
+		assert.Equal(t, api.Response_BAD_REQUEST, resp.GetCode())
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+
+		handler := newTestHandler(t, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil}, &MockZenquoteRepo{GetRandomFunc: nil})
+
+		resp := hello(handler, newSession(), &api.Hello{
+			ProtocolVersion: ProtocolVersion,
+			PowAlgorithms:   nil,
+			Framings:        nil,
+			Compressions:    []string{framing.Gzip},
+		})
+
+		assert.Equal(t, []string{pow.AlgorithmSHA256}, resp.GetHello().GetPowAlgorithms())
+		assert.Equal(t, []string{framing.Newline}, resp.GetHello().GetFramings())
+		// compression needs the length-prefixed framing
+		assert.Equal(t, []string{framing.Identity}, resp.GetHello().GetCompressions())
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Parallel()
+
+		handler := newTestHandler(t, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil}, &MockZenquoteRepo{GetRandomFunc: nil})
+
+		resp := hello(handler, newSession(), &api.Hello{ProtocolVersion: 0})
+		assert.Equal(t, api.Response_UNSUPPORTED, resp.GetCode())
+
+		resp = hello(handler, newSession(), &api.Hello{ProtocolVersion: ProtocolVersion, PowAlgorithms: []string{"md5"}})
+		assert.Equal(t, api.Response_UNSUPPORTED, resp.GetCode())
+	})
+
+	t.Run("cheaper than the default", func(t *testing.T) {
+		t.Parallel()
+
+		cfg := testConfig
+		cfg.Pow.Algorithm = pow.AlgorithmScrypt
+		cfg.Pow.Algorithms = nil
+
+		handler := newTestHandlerWithConfig(t, cfg, nil, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+			&MockZenquoteRepo{GetRandomFunc: nil})
+
+		// a client can not opt out of the memory-hard puzzle
+		resp := hello(handler, newSession(), &api.Hello{
+			ProtocolVersion: ProtocolVersion,
+			PowAlgorithms:   []string{pow.AlgorithmSHA256},
+			Framings:        nil,
+			Compressions:    nil,
+		})
+		assert.Equal(t, api.Response_UNSUPPORTED, resp.GetCode())
+	})
+}
+
+func TestHandleChallengeIDs(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+
+	stored := make(map[string]string)
+	repo := &MockRepo{
+		StoreFunc: func(ctx context.Context, key string, value string, ttl time.Duration) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			stored[key] = value
+
+			return nil
+		},
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			return stored[key], nil
+		},
+		DeleteFunc: func(ctx context.Context, key string) error {
+			mu.Lock()
+			defer mu.Unlock()
+
+			delete(stored, key)
+
+			return nil
+		},
+	}
+	zenRepo := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
+		},
+	}
+
+	cfg := testConfig
+	cfg.Pow.MaxOutstanding = 2
+	handler := newTestHandlerWithConfig(t, cfg, nil, repo, zenRepo)
+
+	// both clients share the NAT address
+	clientIP := "203.0.113.7"
+
+	getChallenge := func() *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil, ChallengeId: ""},
+			ClientIP: clientIP,
+			Session:  nil,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	checkSolution := func(challenge *api.Response, id string) *api.Response {
+		puzzle, err := pow.ParsePuzzle(challenge.GetData())
+		assert.NoError(t, err)
+		assert.NoError(t, puzzle.SolveChallenge())
+
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: puzzle.ToString(), Hello: nil, ChallengeId: id},
+			ClientIP: clientIP,
+			Session:  nil,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	first := getChallenge()
+	second := getChallenge()
+	assert.NotEmpty(t, first.GetChallengeId())
+	assert.NotEqual(t, first.GetChallengeId(), second.GetChallengeId())
+
+	// the cap of outstanding challenges is reached
+	assert.Equal(t, api.Response_TOO_MANY_CHALLENGES, getChallenge().GetCode())
+
+	// the second challenge did not overwrite the first one
+	assert.Equal(t, testQuote.Text, checkSolution(first, first.GetChallengeId()).GetData())
+
+	// a solved challenge frees its slot, clients without challenge ids are still served
+	third := getChallenge()
+	assert.Equal(t, api.Response_SUCCESS, third.GetStatus())
+	assert.Equal(t, testQuote.Text, checkSolution(third, "").GetData())
+
+	// the id must reference the solved challenge
+	fourth := getChallenge()
+	assert.Equal(t, api.Response_CHALLENGE_MISMATCH, checkSolution(second, fourth.GetChallengeId()).GetCode())
+	assert.Equal(t, testQuote.Text, checkSolution(second, second.GetChallengeId()).GetData())
+}
+
+func TestHandleIPv6Client(t *testing.T) {
+	t.Parallel()
+
+	const clientIP = "2001:db8::1"
+
+	stored := make(map[string]string)
+	repo := &MockRepo{
+		StoreFunc: func(ctx context.Context, key string, value string, ttl time.Duration) error {
+			stored[key] = value
+
+			return nil
+		},
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			return stored[key], nil
+		},
+		DeleteFunc: func(ctx context.Context, key string) error {
+			delete(stored, key)
+
+			return nil
+		},
+	}
+	zenRepo := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
+		},
+	}
+	handler := newTestHandler(t, repo, zenRepo)
+
+	writer := &frameBuffer{}
+	handler.Handle(context.Background(), writer, &Request{
+		Request:  &api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil, ChallengeId: ""},
+		ClientIP: clientIP,
+		Session:  nil,
+	})
+
+	challenge := readResponse(t, writer)
+	require.Equal(t, api.Response_SUCCESS, challenge.GetStatus())
+
+	puzzle, err := pow.ParsePuzzle(challenge.GetData())
+	require.NoError(t, err)
+	assert.Equal(t, clientIP, puzzle.Stamp().Resource)
+	require.NoError(t, puzzle.SolveChallenge())
+
+	writer = &frameBuffer{}
+	handler.Handle(context.Background(), writer, &Request{
+		Request: &api.Request{
+			Cmd:         api.Command_CHECK_SOLUTION,
+			Data:        puzzle.ToString(),
+			Hello:       nil,
+			ChallengeId: challenge.GetChallengeId(),
+		},
+		ClientIP: clientIP,
+		Session:  nil,
+	})
+
+	resp := readResponse(t, writer)
+	assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+	assert.Equal(t, testQuote.Text, resp.GetData())
+}
+
+func TestHandleQuoteFilter(t *testing.T) {
+	t.Parallel()
+
+	themed := quote.Quote{ID: "q2", Text: "themed quote", Author: "Lao Tzu", Source: "", Tags: nil, Language: "en"}
+	random := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			return testQuote, nil
+		},
+	}
+	selector := func(err error) ZenquoteRepo {
+		return &MockSelectorRepo{
+			MockZenquoteRepo: *random,
+			SelectFunc: func(ctx context.Context, filter quote.Filter) (quote.Quote, error) {
+				assert.Equal(t, "Lao Tzu", filter.Author)
+				assert.True(t, filter.Daily)
+
+				return themed, err
+			},
+		}
+	}
+	filter := &api.QuoteFilter{Author: "Lao Tzu", Tag: "", Keyword: "", Language: "", Daily: true}
+
+	tests := []struct {
+		name         string
+		zenquoteRepo ZenquoteRepo
+		filter       *api.QuoteFilter
+		expected     string
+		filtered     bool
+	}{
+		{name: "selected", zenquoteRepo: selector(nil), filter: filter, expected: themed.Text, filtered: true},
+		{name: "no filter", zenquoteRepo: selector(nil), filter: nil, expected: testQuote.Text, filtered: false},
+		{name: "no match", zenquoteRepo: selector(quote.ErrNoMatch), filter: filter, expected: testQuote.Text, filtered: false},
+		{name: "unsupported", zenquoteRepo: random, filter: filter, expected: testQuote.Text, filtered: false},
+	}
+
+	for _, tc := range tests {
+		tcCopy := tc
+		t.Run(tcCopy.name, func(t *testing.T) {
+			t.Parallel()
+
+			hc, _ := pow.NewHashcash("127.0.0.1", testConfig.Pow.Bits)
+			issued := hc.ToString()
+			assert.NoError(t, hc.SolveChallenge())
+
+			repo := &MockRepo{
+				StoreFunc: nil,
+				GetFunc: func(ctx context.Context, key string) (string, error) {
+					return issued, nil
+				},
+				DeleteFunc: nil,
+			}
+
+			writer := &frameBuffer{}
+			newTestHandler(t, repo, tcCopy.zenquoteRepo).Handle(context.Background(), writer, &Request{
+				Request: &api.Request{
+					Cmd:         api.Command_CHECK_SOLUTION,
+					Data:        hc.ToString(),
+					Hello:       nil,
+					ChallengeId: "",
+					QuoteFilter: tcCopy.filter,
+				},
+				ClientIP: "127.0.0.1",
+				Session:  nil,
+			})
+
+			resp := readResponse(t, writer)
+			assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+			assert.Equal(t, tcCopy.expected, resp.GetQuote().GetText())
+			assert.Equal(t, tcCopy.filtered, resp.GetFiltered())
+		})
+	}
+}
+
+func TestHandleCredits(t *testing.T) {
+	t.Parallel()
+
+	hc, _ := pow.NewHashcash("127.0.0.1", testConfig.Pow.Bits)
+	issued := hc.ToString()
+	require.NoError(t, hc.SolveChallenge())
+
+	repo := &MockRepo{
+		StoreFunc: nil,
+		GetFunc: func(ctx context.Context, key string) (string, error) {
+			return issued, nil
+		},
+		DeleteFunc: nil,
+	}
+	upstreamDown := false
+	zenRepo := &MockZenquoteRepo{
+		GetRandomFunc: func(ctx context.Context) (quote.Quote, error) {
+			if upstreamDown {
+				return quote.Quote{}, errUpstream
+			}
+
+			return testQuote, nil
+		},
+	}
+
+	cfg := testConfig
+	cfg.Pow.Credits = config.Credits{Enabled: true, Quotes: 2, TTL: time.Minute, Secret: "secret"}
+	handler := newTestHandlerWithConfig(t, cfg, nil, repo, zenRepo)
+
+	send := func(req *api.Request) *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{Request: req, ClientIP: "127.0.0.1", Session: nil})
+
+		return readResponse(t, writer)
+	}
+	getQuote := func(token string) *api.Response {
+		return send(&api.Request{
+			Cmd:         api.Command_GET_QUOTE,
+			Data:        "",
+			Hello:       nil,
+			ChallengeId: "",
+			QuoteFilter: nil,
+			Credit:      token,
+		})
+	}
+
+	// a solved challenge mints a credit along with the quote
+	solved := send(&api.Request{
+		Cmd:         api.Command_CHECK_SOLUTION,
+		Data:        hc.ToString(),
+		Hello:       nil,
+		ChallengeId: "",
+		QuoteFilter: nil,
+		Credit:      "",
+	})
+	require.Equal(t, api.Response_SUCCESS, solved.GetStatus())
+	assert.Equal(t, int64(2), solved.GetCredit().GetRemaining())
+
+	token := solved.GetCredit().GetToken()
+
+	// a quote that could not be served is not paid for
+	upstreamDown = true
+	assert.Equal(t, api.Response_INTERNAL, getQuote(token).GetCode())
+
+	upstreamDown = false
+
+	for _, expected := range []int64{1, 0} {
+		resp := getQuote(token)
+		assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+		assert.Equal(t, testQuote.Text, resp.GetQuote().GetText())
+		assert.Equal(t, expected, resp.GetCredit().GetRemaining())
+	}
+
+	assert.Equal(t, api.Response_CREDIT_INVALID, getQuote(token).GetCode())
+	assert.Equal(t, api.Response_CREDIT_INVALID, getQuote("forged.0.token").GetCode())
+
+	// without credits GET_QUOTE is not supported
+	noCredits := newTestHandler(t, repo, zenRepo)
+	writer := &frameBuffer{}
+	noCredits.Handle(context.Background(), writer, &Request{
+		Request:  &api.Request{Cmd: api.Command_GET_QUOTE, Data: "", Hello: nil, ChallengeId: "", QuoteFilter: nil, Credit: token},
+		ClientIP: "127.0.0.1",
+		Session:  nil,
+	})
+	assert.Equal(t, api.Response_UNSUPPORTED, readResponse(t, writer).GetCode())
+}
+
+func TestHandleChallengeRateLimited(t *testing.T) {
+	t.Parallel()
+
+	stored := 0
+	repo := &MockRepo{
+		StoreFunc: func(ctx context.Context, key string, value string, ttl time.Duration) error {
+			stored++
+
+			return nil
+		},
+		GetFunc:    nil,
+		DeleteFunc: nil,
+	}
+
+	cfg := testConfig
+	cfg.TCP.RateLimit = config.RateLimit{
+		Enabled:     true,
+		IPv4Prefix:  24,
+		IPv6Prefix:  64,
+		MaxClients:  10,
+		Connections: config.Budget{IP: config.Bucket{Rate: 1, Burst: 10}, Subnet: config.Bucket{Rate: 1, Burst: 10}},
+		Challenges:  config.Budget{IP: config.Bucket{Rate: 1, Burst: 10}, Subnet: config.Bucket{Rate: 0.5, Burst: 2}},
+	}
+	cfg.Reputation = config.Reputation{
+		Enabled:         true,
+		Window:          time.Minute,
+		FailedPoints:    1,
+		MalformedPoints: 1,
+		LimitPoints:     1,
+		FreeRequests:    0,
+		RequestPoints:   1,
+		PointsPerBit:    100,
+		MaxPenaltyBits:  1,
+		TrustedSolved:   0,
+		BonusBits:       0,
+	}
+
+	reputationRepo := NewMockReputationRepo()
+	handler := newTestHandlerWithConfig(t, cfg, reputationRepo, repo, &MockZenquoteRepo{GetRandomFunc: nil})
+
+	recorded := func() int64 {
+		reputationRepo.mu.Lock()
+		defer reputationRepo.mu.Unlock()
+
+		var total int64
+
+		for _, fields := range reputationRepo.fields {
+			for _, val := range fields {
+				total += val
+			}
+		}
+
+		return total
+	}
+
+	getChallenge := func(clientIP string) *api.Response {
+		writer := &frameBuffer{}
+		handler.Handle(context.Background(), writer, &Request{
+			Request:  &api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil, ChallengeId: ""},
+			ClientIP: clientIP,
+			Session:  nil,
+		})
+
+		return readResponse(t, writer)
+	}
+
+	// the subnet budget is shared by the addresses of the /24
+	assert.Equal(t, api.Response_SUCCESS, getChallenge("203.0.113.7").GetStatus())
+	assert.Equal(t, api.Response_SUCCESS, getChallenge("203.0.113.8").GetStatus())
+
+	before := recorded()
+	assert.Greater(t, before, int64(0))
+
+	limited := getChallenge("203.0.113.9")
+	assert.Equal(t, api.Response_RATE_LIMITED, limited.GetCode())
+	assert.InDelta(t, 2000, limited.GetRetryAfterMs(), 10)
+
+	// nothing is stored for a limited request, not even its reputation
+	assert.Equal(t, 2, stored)
+	assert.Equal(t, before, recorded())
+	assert.Equal(t, api.Response_SUCCESS, getChallenge("198.51.100.7").GetStatus())
 }
@@ -1,22 +1,56 @@
 package tcp
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
+	"sync"
+	"time"
 	"zenquote/api"
 	"zenquote/internal/config"
+	"zenquote/internal/difficulty"
+	"zenquote/internal/framing"
+	"zenquote/internal/proxyproto"
+	"zenquote/internal/ratelimit"
+	"zenquote/internal/reputation"
+	"zenquote/internal/tlsconfig"
 
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	minAcceptBackoff = 5 * time.Millisecond
+	maxAcceptBackoff = time.Second
+	drainShare       = 2 // draining gets 1/drainShare of the time left to stop, the other stop hooks the rest
+)
+
 type Request struct {
 	*api.Request
 	ClientIP string
+	Session  *Session
+}
+
+// Session holds the protocol settings negotiated with HELLO, it lives as long as the connection.
+// Clients that do not send HELLO keep the defaults.
+type Session struct {
+	Algorithm   string // pow algorithm, empty for the server default
+	Framing     string
+	Compression string
+	negotiated  bool
+	pendingBits int // bits of the challenge issued by the last request, the client is solving it
+}
+
+// challengeIssued records that the client was given a challenge of the given bits to solve.
+func (s *Session) challengeIssued(bits int) {
+	if s != nil {
+		s.pendingBits = bits
+	}
 }
 
 func NewRequest(conn net.Conn, reqBytes []byte) (*Request, error) {
@@ -27,68 +61,282 @@ func NewRequest(conn net.Conn, reqBytes []byte) (*Request, error) {
 
 	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
 
-	return &Request{Request: &reqBody, ClientIP: clientIP}, nil
+	return &Request{Request: &reqBody, ClientIP: clientIP, Session: nil}, nil
 }
 
 type Server struct {
-	cfg       config.TCP
-	logger    *zap.Logger
-	listener  net.Listener
-	handler   *Handler
-	closeChan chan struct{}
+	cfg        config.TCP
+	logger     *zap.Logger
+	listener   net.Listener
+	handler    *Handler
+	difficulty *difficulty.Controller
+	reputation *reputation.Tracker
+	limits     *ratelimit.Limits
+	tls        *tlsconfig.Reloader
+	proxies    *proxyproto.Policy
+	conns      chan struct{} // semaphore of the connections being served, nil for no limit
+	shedding   chan struct{} // semaphore of the connections being answered BUSY
+	closeChan  chan struct{}
+
+	mu       sync.Mutex
+	active   map[net.Conn]struct{} // connections being served or shed, closed if draining them times out
+	closing  bool                  // no connections are tracked once set
+	sessions sync.WaitGroup        // done when the active connections are closed
 }
 
-func NewServer(cfg config.Config, logger *zap.Logger, handler *Handler) *Server {
+func NewServer(
+	cfg config.Config,
+	logger *zap.Logger,
+	handler *Handler,
+	difficulty *difficulty.Controller,
+	reputation *reputation.Tracker,
+	limits *ratelimit.Limits,
+	tlsReloader *tlsconfig.Reloader,
+	proxies *proxyproto.Policy,
+) *Server {
+	var conns chan struct{}
+	if cfg.TCP.Concurrency.MaxConns > 0 {
+		conns = make(chan struct{}, cfg.TCP.Concurrency.MaxConns)
+	}
+
 	return &Server{
-		cfg:       cfg.TCP,
-		logger:    logger,
-		handler:   handler,
-		listener:  nil,
-		closeChan: make(chan struct{}),
+		cfg:        cfg.TCP,
+		logger:     logger,
+		handler:    handler,
+		difficulty: difficulty,
+		reputation: reputation,
+		limits:     limits,
+		tls:        tlsReloader,
+		proxies:    proxies,
+		listener:   nil,
+		conns:      conns,
+		shedding:   make(chan struct{}, cfg.TCP.Concurrency.MaxShedding),
+		closeChan:  make(chan struct{}),
+		mu:         sync.Mutex{},
+		active:     make(map[net.Conn]struct{}),
+		closing:    false,
+		sessions:   sync.WaitGroup{},
 	}
 }
 
 // Start Configure and start TCP server.
 func (s *Server) Start(_ context.Context, stop fx.Shutdowner) {
 	addr := fmt.Sprintf("%s:%d", s.cfg.Host, s.cfg.Port)
-	s.logger.Info("starting server", zap.String("addr", addr))
+	s.logger.Info("starting server", zap.String("addr", addr), zap.Bool("tls", s.tls.Enabled()),
+		zap.Bool("proxyProtocol", s.proxies.Enabled()))
 
-	var err error
-	if s.listener, err = net.Listen("tcp", addr); err != nil {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
 		s.logger.Error("error while starting tcp server", zap.Error(err))
 
 		_ = stop.Shutdown()
+
+		return
 	}
 
+	// the PROXY protocol header comes before the TLS handshake
+	if s.proxies.Enabled() {
+		listener = s.proxies.Listen(listener)
+	}
+
+	if s.tls.Enabled() {
+		listener = tls.NewListener(listener, s.tls.Config())
+	}
+
+	// Shutdown may have run while the listener was being opened, it would not be closed then
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+
+		_ = listener.Close()
+
+		return
+	}
+
+	s.listener = listener
+	s.mu.Unlock()
+
+	var backoff time.Duration
+
 	for {
 		select {
 		case <-s.closeChan:
 			return
 		default:
-			conn, err := s.listener.Accept()
+			conn, err := listener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
 			if err != nil {
-				s.logger.Error("accept connection failed", zap.Error(err))
+				backoff = acceptBackoff(backoff)
+				s.logger.Error("accept connection failed", zap.Error(err), zap.Duration("backoff", backoff))
+
+				select {
+				case <-s.closeChan:
+					return
+				case <-time.After(backoff):
+				}
 
 				continue
 			}
 
-			go func(conn net.Conn) {
-				// Create a context with a timeout
-				ctx, cancelCtx := context.WithTimeout(context.Background(), s.cfg.ReqTimeout)
-				defer cancelCtx()
+			backoff = 0
 
-				// Set conn deadline
-				d, _ := ctx.Deadline()
-				if err = conn.SetDeadline(d); err != nil {
-					s.logger.Error("set connection deadline failed", zap.Error(err))
-					cancelCtx()
+			if !s.accept(conn) {
+				return
+			}
+		}
+	}
+}
 
-					return
-				}
+// accept serves a new connection or turns it away when its client is over the connection rate
+// limit or the server is at its concurrency limit. It returns false once the server is shutting down.
+func (s *Server) accept(conn net.Conn) bool {
+	if !s.track(conn) {
+		_ = conn.Close()
+
+		return false
+	}
+
+	if ok, retryAfter := s.allowConn(conn); !ok {
+		s.shed(conn, retryLaterResponse(api.Response_RATE_LIMITED, "too many connections, retry later", retryAfter))
+
+		return true
+	}
+
+	if !tryAcquire(s.conns) {
+		s.shed(conn, retryLaterResponse(api.Response_BUSY, "server busy, retry later", s.cfg.Concurrency.RetryAfter))
+
+		return true
+	}
+
+	s.difficulty.ConnAccepted()
+
+	go s.serve(conn)
 
-				s.handleConn(ctx, conn)
-			}(conn)
+	return true
+}
+
+// allowConn takes a token of the connection rate limit of the client. The client address of a
+// connection from a trusted proxy is only known once its PROXY header is read, which the accept
+// loop does not wait for, so those connections are limited by handleConn.
+func (s *Server) allowConn(conn net.Conn) (bool, time.Duration) {
+	if behindProxy(conn) {
+		return true, 0
+	}
+
+	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	ok, retryAfter := s.limits.Connections.Allow(clientIP)
+	if !ok {
+		s.logger.Debug("connection rate limited", zap.String("clientIP", clientIP), zap.Duration("retryAfter", retryAfter))
+	}
+
+	return ok, retryAfter
+}
+
+// allowProxied takes a token of the connection rate limit of the client of a connection from
+// a trusted proxy, its PROXY header has been read.
+func (s *Server) allowProxied(conn net.Conn, clientIP string) (bool, time.Duration) {
+	if !behindProxy(conn) {
+		return true, 0
+	}
+
+	return s.limits.Connections.Allow(clientIP)
+}
+
+// behindProxy reports whether the connection starts with a PROXY protocol header.
+func behindProxy(conn net.Conn) bool {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+
+	_, ok := conn.(*proxyproto.Conn)
+
+	return ok
+}
+
+// serve runs the session of a tracked connection that holds a slot of the concurrency limit.
+func (s *Server) serve(conn net.Conn) {
+	defer s.untrack(conn)
+	defer release(s.conns)
+	defer s.difficulty.ConnClosed()
+
+	// the session context ends with the session, the timeouts of each phase are set by handleConn
+	ctx := context.Background()
+	if s.cfg.Timeouts.MaxSession > 0 {
+		var cancelCtx context.CancelFunc
+
+		ctx, cancelCtx = context.WithTimeout(ctx, s.cfg.Timeouts.MaxSession)
+		defer cancelCtx()
+	}
+
+	s.handleConn(ctx, conn)
+}
+
+// shed answers resp to a connection turned away without reading any request, or closes it
+// right away when as many connections as allowed are being answered already.
+func (s *Server) shed(conn net.Conn, resp *api.Response) {
+	if !tryAcquire(s.shedding) {
+		_ = conn.Close()
+		s.untrack(conn)
+
+		return
+	}
+
+	go func() {
+		defer s.untrack(conn)
+		defer release(s.shedding)
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		if err := conn.SetDeadline(time.Now().Add(s.cfg.Concurrency.ShedTimeout)); err != nil {
+			return
+		}
+
+		// the framing is detected first so the client can read why it is turned away
+		codec, err := s.readFromConnection(conn)
+		if err != nil {
+			return
 		}
+
+		s.writeResponse(codec, resp)
+	}()
+}
+
+// acceptBackoff returns the delay before accepting again after a failed accept,
+// doubled after each failure in a row, so a lasting error does not spin the loop.
+func acceptBackoff(previous time.Duration) time.Duration {
+	if previous == 0 {
+		return minAcceptBackoff
+	}
+
+	if previous*2 > maxAcceptBackoff {
+		return maxAcceptBackoff
+	}
+
+	return previous * 2
+}
+
+// tryAcquire takes a slot of the semaphore without waiting, a nil semaphore has no limit.
+func tryAcquire(sem chan struct{}) bool {
+	if sem == nil {
+		return true
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func release(sem chan struct{}) {
+	if sem != nil {
+		<-sem
 	}
 }
 
@@ -97,49 +345,204 @@ func (s *Server) handleConn(ctx context.Context, conn net.Conn) {
 		_ = conn.Close()
 	}(conn)
 
+	// the session ends with ctx, the deadline of each read and write is no later than that
+	end, _ := ctx.Deadline()
+
+	// the PROXY protocol header, the TLS handshake and the framing are read within the idle timeout
+	if err := s.setDeadlines(conn, end); err != nil {
+		s.logger.Error("set connection deadline failed", zap.Error(err))
+
+		return
+	}
+
 	reqCount := 0
-	scanner := s.readFromConnection(conn)
+	clientIP, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+
+	codec, err := s.readFromConnection(conn)
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			s.logger.Error("framing negotiation failed", zap.Error(err))
+			s.reputation.Record(ctx, clientIP, reputation.EventMalformedRequest)
+		}
+
+		return
+	}
+
+	writer := &deadlineWriter{writer: codec, conn: conn, timeout: s.cfg.Timeouts.Write, end: end}
+
+	// the framing is detected first so the client can read why it is turned away,
+	// connections not from a proxy have been limited on accept
+	if ok, retryAfter := s.allowProxied(conn, clientIP); !ok {
+		s.logger.Debug("connection rate limited", zap.String("clientIP", clientIP), zap.Duration("retryAfter", retryAfter))
+		s.writeResponse(writer, retryLaterResponse(api.Response_RATE_LIMITED, "too many connections, retry later", retryAfter))
+
+		return
+	}
+
+	session := &Session{
+		Algorithm:   "",
+		Framing:     codec.Name(),
+		Compression: framing.Identity,
+		negotiated:  false,
+		pendingBits: 0,
+	}
+	applied := *session
+
+	for {
+		// a client given a challenge has the time to solve it on top of the idle timeout
+		idle := s.cfg.Timeouts.Idle + solveBudget(s.cfg.Timeouts, session.pendingBits)
+		session.pendingBits = 0
+
+		if err := conn.SetReadDeadline(deadline(idle, end)); err != nil {
+			s.logger.Error("set read deadline failed", zap.Error(err))
+
+			return
+		}
+
+		frame, err := codec.ReadFrame()
+		if errors.Is(err, framing.ErrFrameTooLarge) {
+			s.writeErr(writer, "request too large")
+			s.reputation.Record(ctx, clientIP, reputation.EventMalformedRequest)
+
+			return
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				s.logger.Error("reading from connection failed", zap.Error(err))
+			}
+
+			return
+		}
 
-	for scanner.Scan() {
 		reqCount++
 
 		// Validate request
-		if !s.validateReqSize(scanner.Bytes(), conn) || !s.validateReqLimit(reqCount, conn) {
-			break
+		if !s.validateReqSize(frame, writer) {
+			s.reputation.Record(ctx, clientIP, reputation.EventMalformedRequest)
+
+			return
+		}
+
+		if !s.validateReqLimit(reqCount, writer) {
+			s.reputation.Record(ctx, clientIP, reputation.EventSessionLimit)
+
+			return
 		}
 
 		// Create Request
-		req, err := NewRequest(conn, scanner.Bytes())
+		req, err := NewRequest(conn, frame)
 		if err != nil {
 			s.logger.Error("failed to create request", zap.Error(err))
+			s.reputation.Record(ctx, clientIP, reputation.EventMalformedRequest)
 
 			return
 		}
 
-		s.handler.Handle(ctx, conn, req)
+		req.Session = session
+		s.handle(ctx, writer, req)
+
+		// the response to HELLO is written with the previous framing, the negotiated one starts with the next frame
+		if session.Framing != applied.Framing || session.Compression != applied.Compression {
+			if codec, err = framing.Switch(codec, session.Framing, session.Compression); err != nil {
+				s.logger.Error("switch framing failed", zap.Error(err))
+
+				return
+			}
+
+			writer.writer = codec
+		}
+
+		applied = *session
 	}
+}
+
+// handle handles the request within the request timeout, the session context bounds it as well.
+func (s *Server) handle(ctx context.Context, respWriter framing.Writer, req *Request) {
+	if s.cfg.ReqTimeout > 0 {
+		var cancel context.CancelFunc
 
-	if err := scanner.Err(); err != nil {
-		s.logger.Error("reading from connection failed", zap.Error(err))
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.ReqTimeout)
+		defer cancel()
 	}
+
+	s.handler.Handle(ctx, respWriter, req)
 }
 
-func (s *Server) readFromConnection(conn net.Conn) *bufio.Scanner {
-	scanner := bufio.NewScanner(conn)
-	buf := make([]byte, 0, s.cfg.MaxReqSizeBytes)
-	scanner.Buffer(buf, s.cfg.MaxReqSizeBytes)
+// setDeadlines sets the idle timeout for reading and the write timeout for writing.
+func (s *Server) setDeadlines(conn net.Conn, end time.Time) error {
+	if err := conn.SetReadDeadline(deadline(s.cfg.Timeouts.Idle, end)); err != nil {
+		return fmt.Errorf("set read deadline failed: %w", err)
+	}
+
+	if err := conn.SetWriteDeadline(deadline(s.cfg.Timeouts.Write, end)); err != nil {
+		return fmt.Errorf("set write deadline failed: %w", err)
+	}
 
-	return scanner
+	return nil
 }
 
-func (s *Server) validateReqSize(data []byte, respWrite io.Writer) bool {
+// deadlineWriter gives each response the write timeout, so a client that stops reading
+// does not hold its connection until the end of the session.
+type deadlineWriter struct {
+	writer  framing.Writer
+	conn    net.Conn
+	timeout time.Duration
+	end     time.Time
+}
+
+func (w *deadlineWriter) WriteFrame(frame []byte) error {
+	if err := w.conn.SetWriteDeadline(deadline(w.timeout, w.end)); err != nil {
+		return fmt.Errorf("set write deadline failed: %w", err)
+	}
+
+	return w.writer.WriteFrame(frame)
+}
+
+// deadline returns when a timeout starting now ends, no later than the end of the session.
+// A zero timeout or end does not limit it.
+func deadline(timeout time.Duration, end time.Time) time.Time {
+	if timeout <= 0 {
+		return end
+	}
+
+	d := time.Now().Add(timeout)
+	if !end.IsZero() && end.Before(d) {
+		return end
+	}
+
+	return d
+}
+
+// solveBudget returns the time given to solve a challenge of the given bits: the solve timeout
+// at solveBits, doubled for each bit more and halved for each bit less, as the expected work is.
+func solveBudget(cfg config.Timeouts, bits int) time.Duration {
+	if bits <= 0 || cfg.Solve <= 0 {
+		return 0
+	}
+
+	budget := math.Ldexp(float64(cfg.Solve), bits-cfg.SolveBits)
+	if budget >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+
+	return time.Duration(budget)
+}
+
+// readFromConnection detects the framing used by the client, legacy newline-delimited
+// or length-prefixed, and returns the codec for the rest of the session.
+func (s *Server) readFromConnection(conn net.Conn) (framing.Codec, error) {
+	codec, err := framing.Accept(conn, s.cfg.MaxReqSizeBytes)
+	if err != nil {
+		return nil, fmt.Errorf("accept framing failed: %w", err)
+	}
+
+	return codec, nil
+}
+
+func (s *Server) validateReqSize(data []byte, respWriter framing.Writer) bool {
 	if len(data) >= s.cfg.MaxReqSizeBytes {
-		respBytes, _ := proto.Marshal(&api.Response{
-			Status:   api.Response_SUCCESS,
-			Response: &api.Response_Error{Error: "request too large"},
-		})
-		respBytes = append(respBytes, '\n')
-		_, _ = respWrite.Write(respBytes)
+		s.writeErr(respWriter, "request too large")
 
 		return false
 	}
@@ -147,14 +550,9 @@ func (s *Server) validateReqSize(data []byte, respWrite io.Writer) bool {
 	return true
 }
 
-func (s *Server) validateReqLimit(reqCount int, respWrite io.Writer) bool {
+func (s *Server) validateReqLimit(reqCount int, respWriter framing.Writer) bool {
 	if reqCount > s.cfg.MaxReqPerSession {
-		respBytes, _ := proto.Marshal(&api.Response{
-			Status:   api.Response_FAILURE,
-			Response: &api.Response_Error{Error: "session request limit exceeded"},
-		})
-		respBytes = append(respBytes, '\n')
-		_, _ = respWrite.Write(respBytes)
+		s.writeErr(respWriter, "session request limit exceeded")
 
 		return false
 	}
@@ -162,14 +560,97 @@ func (s *Server) validateReqLimit(reqCount int, respWrite io.Writer) bool {
 	return true
 }
 
-// Shutdown stops the server and cleans up any resources it was using.
+// writeErr writes a session level error response, the connection is closed right after it.
+func (s *Server) writeErr(respWriter framing.Writer, msg string) {
+	s.writeResponse(respWriter, &api.Response{
+		Status:   api.Response_FAILURE,
+		Response: &api.Response_Error{Error: msg},
+		Code:     api.Response_BAD_REQUEST,
+	})
+}
+
+func (s *Server) writeResponse(respWriter framing.Writer, response *api.Response) {
+	respBytes, _ := proto.Marshal(response)
+	_ = respWriter.WriteFrame(respBytes)
+}
+
+// Shutdown stops accepting connections and waits for the active sessions to finish.
+// If ctx has a deadline, the sessions get a share of the time left so the services stopped after
+// the server still have time. The sessions still running then are cut off by closing their connections.
 // Calling Shutdown multiple times or while the server is already stopped will cause a runtime panic.
 // Ensure that Shutdown is called exactly once when the server is no longer needed.
-func (s *Server) Shutdown() {
+func (s *Server) Shutdown(ctx context.Context) {
 	s.logger.Info("stopping server")
 	close(s.closeChan)
 
-	if err := s.listener.Close(); err != nil {
-		s.logger.Error("close tcp listener failed", zap.Error(err))
+	s.mu.Lock()
+	s.closing = true
+	listener := s.listener
+	draining := len(s.active)
+	s.mu.Unlock()
+
+	if listener != nil {
+		if err := listener.Close(); err != nil {
+			s.logger.Error("close tcp listener failed", zap.Error(err))
+		}
+	}
+
+	s.logger.Info("draining sessions", zap.Int("sessions", draining))
+
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/drainShare)
+		defer cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.logger.Info("server stopped", zap.Int("drained", draining))
+	case <-ctx.Done():
+		forced := s.closeActive()
+		s.logger.Warn("server stopped, sessions cut off",
+			zap.Int("drained", draining-forced), zap.Int("forced", forced))
+	}
+}
+
+// track records a new connection, false once the server is shutting down.
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
 	}
+
+	s.active[conn] = struct{}{}
+	s.sessions.Add(1)
+
+	return true
+}
+
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.active, conn)
+	s.sessions.Done()
+}
+
+// closeActive closes the connections still active and returns how many there were.
+func (s *Server) closeActive() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn := range s.active {
+		_ = conn.Close()
+	}
+
+	return len(s.active)
 }
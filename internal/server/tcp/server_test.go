@@ -1,12 +1,22 @@
 package tcp
 
 import (
+	"bufio"
+	"context"
+	"io"
+	"math"
 	"net"
+	"os"
 	"testing"
+	"time"
 	"zenquote/api"
 	"zenquote/internal/config"
+	"zenquote/internal/framing"
+	"zenquote/internal/proxyproto"
+	"zenquote/internal/tlsconfig"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 
 	"google.golang.org/protobuf/proto"
@@ -64,10 +74,11 @@ func TestReadFromConnection(t *testing.T) {
 			MaxReqPerSession: 0,
 		},
 		Redis:  config.Redis{Host: "", Port: 0},
+		Pow:    config.Pow{Bits: 0, Difficulty: config.Difficulty{}},
 		Logger: config.Logger{Level: "", Encoding: "", Colored: false, Tags: nil},
 	}
 
-	server := NewServer(cfg, zap.NewNop(), nil)
+	server := NewServer(cfg, zap.NewNop(), nil, nil, nil, nil, nil, nil)
 
 	conn1, conn2 := net.Pipe()
 	defer func(conn1 net.Conn) {
@@ -78,21 +89,70 @@ func TestReadFromConnection(t *testing.T) {
 	}(conn2)
 
 	go func() {
-		_, _ = conn2.Write([]byte("test data"))
+		_, _ = conn2.Write([]byte("test data\n"))
 		_ = conn2.Close()
 	}()
 
-	scanner := server.readFromConnection(conn1)
-	scanner.Scan() // call Scan() before checking the size of scanner buffer
+	codec, err := server.readFromConnection(conn1)
+	if err != nil {
+		t.Fatalf("Failed to accept framing: %s", err)
+	}
+
+	frame, err := codec.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %s", err)
+	}
+
+	assert.Equal(t, "test data", string(frame))
+}
+
+func TestReadFromConnectionLengthPrefixed(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Config{
+		TCP: config.TCP{
+			Host:             "",
+			Port:             0,
+			ReqTimeout:       0,
+			MaxReqSizeBytes:  2048,
+			MaxReqPerSession: 0,
+		},
+		Redis:  config.Redis{Host: "", Port: 0},
+		Pow:    config.Pow{Bits: 0, Difficulty: config.Difficulty{}},
+		Logger: config.Logger{Level: "", Encoding: "", Colored: false, Tags: nil},
+	}
+
+	server := NewServer(cfg, zap.NewNop(), nil, nil, nil, nil, nil, nil)
+
+	conn1, conn2 := net.Pipe()
+	defer func(conn1 net.Conn) {
+		_ = conn1.Close()
+	}(conn1)
+	defer func(conn2 net.Conn) {
+		_ = conn2.Close()
+	}(conn2)
 
-	assert.NotNil(t, scanner)
-	assert.Equal(t, cfg.TCP.MaxReqSizeBytes, cap(scanner.Bytes()))
+	// the frame contains a newline, which the legacy framing would split
+	reqBytes, _ := proto.Marshal(&api.Request{Cmd: api.Command_CHECK_SOLUTION, Data: "1:12:0:ip::\n:0"})
 
-	if err := scanner.Err(); err != nil {
-		t.Error("Failed to scan from connection")
+	go func() {
+		client, err := framing.Dial(conn2, cfg.TCP.MaxReqSizeBytes)
+		if err == nil {
+			_ = client.WriteFrame(reqBytes)
+		}
+	}()
+
+	codec, err := server.readFromConnection(conn1)
+	if err != nil {
+		t.Fatalf("Failed to accept framing: %s", err)
 	}
 
-	assert.Equal(t, "test data", scanner.Text())
+	frame, err := codec.ReadFrame()
+	if err != nil {
+		t.Fatalf("Failed to read from connection: %s", err)
+	}
+
+	assert.Equal(t, reqBytes, frame)
 }
 
 func TestIsValidReqSize(t *testing.T) {
@@ -131,10 +191,11 @@ func TestIsValidReqSize(t *testing.T) {
 					MaxReqPerSession: 0,
 				},
 				Redis:  config.Redis{Host: "", Port: 0},
+				Pow:    config.Pow{Bits: 0, Difficulty: config.Difficulty{}},
 				Logger: config.Logger{Level: "", Encoding: "", Colored: false, Tags: nil},
 			}
 
-			server := NewServer(cfg, zap.NewNop(), nil)
+			server := NewServer(cfg, zap.NewNop(), nil, nil, nil, nil, nil, nil)
 
 			// Write data to connection and close it
 			go func() {
@@ -146,7 +207,7 @@ func TestIsValidReqSize(t *testing.T) {
 			buf := make([]byte, tcCopy.maxSize+1)
 			n, _ := conn1.Read(buf)
 
-			assert.Equal(t, tcCopy.expected, server.validateReqSize(buf[:n], conn1))
+			assert.Equal(t, tcCopy.expected, server.validateReqSize(buf[:n], framing.NewLineCodec(nil, conn1, 0)))
 		})
 	}
 }
@@ -189,10 +250,11 @@ func TestIsValidReqLimit(t *testing.T) {
 					MaxReqPerSession: tcCopy.maxCount,
 				},
 				Redis:  config.Redis{Host: "", Port: 0},
+				Pow:    config.Pow{Bits: 0, Difficulty: config.Difficulty{}},
 				Logger: config.Logger{Level: "", Encoding: "", Colored: false, Tags: nil},
 			}
 
-			server := NewServer(cfg, zap.NewNop(), nil)
+			server := NewServer(cfg, zap.NewNop(), nil, nil, nil, nil, nil, nil)
 
 			// Create a separate goroutine to handle potential server writes.
 			go func() {
@@ -205,10 +267,467 @@ func TestIsValidReqLimit(t *testing.T) {
 			}()
 
 			// Assert our condition.
-			assert.Equal(t, tcCopy.expected, server.validateReqLimit(tcCopy.reqCount, serverConn))
+			assert.Equal(t, tcCopy.expected, server.validateReqLimit(tcCopy.reqCount, framing.NewLineCodec(nil, serverConn, 0)))
 
 			// Clean up the server side of the connection.
 			_ = serverConn.Close()
 		})
 	}
 }
+
+func TestHandleConnHelloSwitchesFraming(t *testing.T) {
+	t.Parallel()
+
+	handler := newTestHandler(t, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(testConfig, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	go server.handleConn(context.Background(), serverConn)
+
+	// a legacy newline client upgrades with HELLO
+	var codec framing.Codec = framing.NewLineCodec(bufio.NewReader(clientConn), clientConn, 0)
+
+	reqBytes, _ := proto.Marshal(&api.Request{Cmd: api.Command_HELLO, Data: "", Hello: &api.Hello{
+		ProtocolVersion: ProtocolVersion,
+		PowAlgorithms:   nil,
+		Framings:        []string{framing.LengthPrefixed},
+		Compressions:    []string{framing.Gzip},
+	}})
+	assert.NoError(t, codec.WriteFrame(reqBytes))
+
+	frame, err := codec.ReadFrame()
+	assert.NoError(t, err)
+
+	resp := &api.Response{Status: 0, Response: nil, Code: 0, Hello: nil}
+	assert.NoError(t, proto.Unmarshal(frame, resp))
+	assert.Equal(t, []string{framing.Gzip}, resp.GetHello().GetCompressions())
+
+	codec, err = framing.Switch(codec, framing.LengthPrefixed, framing.Gzip)
+	assert.NoError(t, err)
+
+	reqBytes, _ = proto.Marshal(&api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil})
+	assert.NoError(t, codec.WriteFrame(reqBytes))
+
+	frame, err = codec.ReadFrame()
+	assert.NoError(t, err)
+	assert.NoError(t, proto.Unmarshal(frame, resp))
+	assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+	assert.NotEmpty(t, resp.GetData())
+}
+
+func rateLimitConfig() config.Config {
+	cfg := testConfig
+	cfg.TCP.RateLimit = config.RateLimit{
+		Enabled:     true,
+		IPv4Prefix:  24,
+		IPv6Prefix:  64,
+		MaxClients:  10,
+		Connections: config.Budget{IP: config.Bucket{Rate: 0.001, Burst: 1}, Subnet: config.Bucket{Rate: 1, Burst: 10}},
+		Challenges:  config.Budget{IP: config.Bucket{Rate: 1, Burst: 10}, Subnet: config.Bucket{Rate: 1, Burst: 10}},
+	}
+	cfg.TCP.Concurrency = config.Concurrency{MaxConns: 0, MaxShedding: 1, ShedTimeout: time.Second, RetryAfter: 0}
+
+	return cfg
+}
+
+func readTestResponse(t *testing.T, codec framing.Codec) *api.Response {
+	t.Helper()
+
+	frame, err := codec.ReadFrame()
+	assert.NoError(t, err)
+
+	resp := &api.Response{}
+	assert.NoError(t, proto.Unmarshal(frame, resp))
+
+	return resp
+}
+
+func TestAcceptRateLimited(t *testing.T) {
+	t.Parallel()
+
+	cfg := rateLimitConfig()
+	handler := newTestHandlerWithConfig(t, cfg, nil, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(cfg, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	// pipes share the same remote address, so both connections come from one client
+	dial := func() (framing.Codec, func()) {
+		serverConn, clientConn := net.Pipe()
+		assert.True(t, server.accept(serverConn))
+
+		codec, err := framing.Dial(clientConn, 0)
+		assert.NoError(t, err)
+
+		return codec, func() {
+			_ = clientConn.Close()
+		}
+	}
+
+	codec, closeConn := dial()
+	reqBytes, _ := proto.Marshal(&api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil})
+	assert.NoError(t, codec.WriteFrame(reqBytes))
+	assert.Equal(t, api.Response_SUCCESS, readTestResponse(t, codec).GetStatus())
+	closeConn()
+
+	// the limited connection is shed without being served
+	codec, closeConn = dial()
+	defer closeConn()
+
+	limited := readTestResponse(t, codec)
+	assert.Equal(t, api.Response_RATE_LIMITED, limited.GetCode())
+	assert.Greater(t, limited.GetRetryAfterMs(), uint32(0))
+}
+
+func TestHandleConnRateLimitedBehindProxy(t *testing.T) {
+	t.Parallel()
+
+	cfg := rateLimitConfig()
+	handler := newTestHandlerWithConfig(t, cfg, nil, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(cfg, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	// the proxy connects from the same address each time, the client comes from the header
+	dial := func(clientIP string) (framing.Codec, func()) {
+		serverConn, clientConn := net.Pipe()
+
+		go server.handleConn(context.Background(), proxyproto.NewConn(serverConn))
+
+		_, err := clientConn.Write([]byte("PROXY TCP4 " + clientIP + " 198.51.100.1 56324 443\r\n"))
+		assert.NoError(t, err)
+
+		codec, err := framing.Dial(clientConn, 0)
+		assert.NoError(t, err)
+
+		return codec, func() {
+			_ = clientConn.Close()
+		}
+	}
+
+	codec, closeConn := dial("192.0.2.1")
+	reqBytes, _ := proto.Marshal(&api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil})
+	assert.NoError(t, codec.WriteFrame(reqBytes))
+	assert.Equal(t, api.Response_SUCCESS, readTestResponse(t, codec).GetStatus())
+	closeConn()
+
+	// the limited client is answered without reading a request, another one behind the proxy is not limited
+	codec, closeConn = dial("192.0.2.1")
+	assert.Equal(t, api.Response_RATE_LIMITED, readTestResponse(t, codec).GetCode())
+	closeConn()
+
+	codec, closeConn = dial("192.0.2.2")
+	defer closeConn()
+
+	assert.NoError(t, codec.WriteFrame(reqBytes))
+	assert.Equal(t, api.Response_SUCCESS, readTestResponse(t, codec).GetStatus())
+}
+
+func TestShed(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig
+	cfg.TCP.Concurrency = config.Concurrency{
+		MaxConns:    1,
+		MaxShedding: 1,
+		ShedTimeout: time.Second,
+		RetryAfter:  1500 * time.Millisecond,
+	}
+	server := NewServer(cfg, zap.NewNop(), nil, nil, nil, nil, nil, nil)
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	assert.True(t, server.track(serverConn))
+	server.shed(serverConn, retryLaterResponse(api.Response_BUSY, "server busy, retry later", 1500*time.Millisecond))
+
+	codec, err := framing.Dial(clientConn, 0)
+	assert.NoError(t, err)
+
+	frame, err := codec.ReadFrame()
+	assert.NoError(t, err)
+
+	resp := &api.Response{}
+	assert.NoError(t, proto.Unmarshal(frame, resp))
+	assert.Equal(t, api.Response_BUSY, resp.GetCode())
+	assert.Equal(t, uint32(1500), resp.GetRetryAfterMs())
+
+	// the connection is closed without an answer once as many are being shed as allowed
+	server.shedding <- struct{}{}
+
+	serverConn, otherConn := net.Pipe()
+	defer func(otherConn net.Conn) {
+		_ = otherConn.Close()
+	}(otherConn)
+
+	assert.True(t, server.track(serverConn))
+	server.shed(serverConn, retryLaterResponse(api.Response_BUSY, "server busy, retry later", 0))
+
+	_, err = otherConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	t.Parallel()
+
+	var backoff time.Duration
+
+	for _, expected := range []time.Duration{5, 10, 20, 40, 80, 160, 320, 640, 1000, 1000} {
+		backoff = acceptBackoff(backoff)
+		assert.Equal(t, expected*time.Millisecond, backoff)
+	}
+}
+
+func TestTryAcquire(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, tryAcquire(nil))
+
+	sem := make(chan struct{}, 1)
+	assert.True(t, tryAcquire(sem))
+	assert.False(t, tryAcquire(sem))
+
+	release(sem)
+	assert.True(t, tryAcquire(sem))
+}
+
+func TestShutdownDrainsSessions(t *testing.T) {
+	t.Parallel()
+
+	handler := newTestHandler(t, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(testConfig, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	serverConn, clientConn := net.Pipe()
+	assert.True(t, server.track(serverConn))
+
+	go server.serve(serverConn)
+
+	codec, err := framing.Dial(clientConn, 0)
+	assert.NoError(t, err)
+
+	stopped := make(chan struct{})
+
+	go func() {
+		server.Shutdown(context.Background())
+		close(stopped)
+	}()
+
+	// the session goes on while the server is stopping
+	reqBytes, _ := proto.Marshal(&api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil})
+	assert.NoError(t, codec.WriteFrame(reqBytes))
+
+	frame, err := codec.ReadFrame()
+	assert.NoError(t, err)
+
+	resp := &api.Response{}
+	assert.NoError(t, proto.Unmarshal(frame, resp))
+	assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before the session ended")
+	default:
+	}
+
+	_ = clientConn.Close()
+	<-stopped
+
+	// no new connections are taken once stopping
+	otherConn, _ := net.Pipe()
+	assert.False(t, server.track(otherConn))
+}
+
+func TestShutdownCutsOffSessions(t *testing.T) {
+	t.Parallel()
+
+	handler := newTestHandler(t, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(testConfig, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	assert.True(t, server.track(serverConn))
+
+	go server.serve(serverConn)
+
+	_, err := framing.Dial(clientConn, 0)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	server.Shutdown(ctx)
+
+	// half of the stop timeout is left to the services stopped after the server
+	assert.NoError(t, ctx.Err())
+
+	_, err = clientConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestStartAfterShutdown(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig
+	cfg.TCP.Host = "127.0.0.1"
+
+	reloader, err := tlsconfig.NewReloader(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	proxies, err := proxyproto.NewPolicy(cfg)
+	require.NoError(t, err)
+
+	server := NewServer(cfg, zap.NewNop(), nil, nil, nil, nil, reloader, proxies)
+	server.Shutdown(context.Background())
+
+	// the listener opened after the shutdown is closed rather than left serving
+	server.Start(context.Background(), nil)
+	assert.Nil(t, server.listener)
+}
+
+func TestSolveBudget(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Timeouts{Idle: 0, Write: 0, Solve: 10 * time.Second, SolveBits: 20, MaxSession: 0}
+
+	tests := []struct {
+		bits     int
+		expected time.Duration
+	}{
+		{bits: 0, expected: 0},
+		{bits: 20, expected: 10 * time.Second},
+		{bits: 22, expected: 40 * time.Second},
+		{bits: 18, expected: 2500 * time.Millisecond},
+		{bits: 200, expected: math.MaxInt64},
+	}
+
+	for _, tc := range tests {
+		assert.Equal(t, tc.expected, solveBudget(cfg, tc.bits), "bits %d", tc.bits)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	t.Parallel()
+
+	end := time.Now().Add(time.Minute)
+
+	assert.Equal(t, end, deadline(0, end))
+	assert.Equal(t, end, deadline(time.Hour, end))
+	assert.True(t, deadline(0, time.Time{}).IsZero())
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline(time.Second, end), 100*time.Millisecond)
+	assert.WithinDuration(t, time.Now().Add(time.Second), deadline(time.Second, time.Time{}), 100*time.Millisecond)
+}
+
+func TestDeadlineWriter(t *testing.T) {
+	t.Parallel()
+
+	serverConn, clientConn := net.Pipe()
+	defer func() {
+		_ = serverConn.Close()
+		_ = clientConn.Close()
+	}()
+
+	// the client never reads
+	writer := &deadlineWriter{
+		writer:  framing.NewLengthPrefixedCodec(nil, serverConn, 0),
+		conn:    serverConn,
+		timeout: 20 * time.Millisecond,
+		end:     time.Time{},
+	}
+
+	assert.ErrorIs(t, writer.WriteFrame([]byte("response")), os.ErrDeadlineExceeded)
+}
+
+func TestHandleConnTimeouts(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig
+	cfg.TCP.Timeouts = config.Timeouts{
+		Idle:       100 * time.Millisecond,
+		Write:      time.Second,
+		Solve:      300 * time.Millisecond,
+		SolveBits:  cfg.Pow.Bits,
+		MaxSession: 0,
+	}
+
+	handler := newTestHandlerWithConfig(t, cfg, nil, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(cfg, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	closed := make(chan struct{})
+
+	go func() {
+		server.handleConn(context.Background(), serverConn)
+		close(closed)
+	}()
+
+	codec, err := framing.Dial(clientConn, 0)
+	assert.NoError(t, err)
+
+	getChallenge := func() {
+		reqBytes, _ := proto.Marshal(&api.Request{Cmd: api.Command_GET_CHALLENGE, Data: "", Hello: nil})
+		assert.NoError(t, codec.WriteFrame(reqBytes))
+
+		frame, err := codec.ReadFrame()
+		assert.NoError(t, err)
+
+		resp := &api.Response{}
+		assert.NoError(t, proto.Unmarshal(frame, resp))
+		assert.Equal(t, api.Response_SUCCESS, resp.GetStatus())
+	}
+
+	// the client solving the challenge is waited for longer than the idle timeout
+	getChallenge()
+	time.Sleep(200 * time.Millisecond)
+	getChallenge()
+
+	// an idle client is cut off after the idle timeout and its solve budget
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session was not closed")
+	}
+}
+
+func TestServeMaxSession(t *testing.T) {
+	t.Parallel()
+
+	cfg := testConfig
+	cfg.TCP.Timeouts = config.Timeouts{Idle: time.Minute, Write: 0, Solve: 0, SolveBits: 0, MaxSession: 50 * time.Millisecond}
+
+	handler := newTestHandlerWithConfig(t, cfg, nil, &MockRepo{StoreFunc: nil, GetFunc: nil, DeleteFunc: nil},
+		&MockZenquoteRepo{GetRandomFunc: nil})
+	server := NewServer(cfg, zap.NewNop(), handler, handler.difficulty, handler.reputation, handler.limits, nil, nil)
+
+	serverConn, clientConn := net.Pipe()
+	defer func(clientConn net.Conn) {
+		_ = clientConn.Close()
+	}(clientConn)
+
+	assert.True(t, server.track(serverConn))
+
+	go server.serve(serverConn)
+
+	_, err := framing.Dial(clientConn, 0)
+	assert.NoError(t, err)
+
+	start := time.Now()
+
+	_, err = clientConn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Less(t, time.Since(start), time.Second)
+}
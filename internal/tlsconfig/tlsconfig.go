@@ -0,0 +1,198 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"zenquote/internal/config"
+
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidConfig  = errors.New("invalid tls config")
+	ErrUnknownVersion = errors.New("unknown tls version")
+	ErrInvalidCA      = errors.New("no certificates found in CA file")
+)
+
+// Reloader serves the TLS settings of the TCP server. The certificate, its key and the client CAs
+// are read again when their files change, so certificates are renewed without a restart.
+// Changes are looked for on handshakes, at most once per reload interval.
+type Reloader struct {
+	cfg        config.TLS
+	minVersion uint16
+	logger     *zap.Logger
+	now        func() time.Time
+
+	mu      sync.Mutex
+	current *tls.Config
+	loaded  string // stamp of the files current was loaded from
+	checked time.Time
+}
+
+func NewReloader(cfg config.Config, logger *zap.Logger) (*Reloader, error) {
+	tcfg := cfg.TCP.TLS
+
+	r := &Reloader{
+		cfg:        tcfg,
+		minVersion: 0,
+		logger:     logger,
+		now:        time.Now,
+		mu:         sync.Mutex{},
+		current:    nil,
+		loaded:     "",
+		checked:    time.Time{},
+	}
+
+	if !tcfg.Enabled {
+		return r, nil
+	}
+
+	if tcfg.CertFile == "" || tcfg.KeyFile == "" {
+		return nil, fmt.Errorf("%w: a certificate and a key file are required", ErrInvalidConfig)
+	}
+
+	// every handshake would stat the files under the lock otherwise
+	if tcfg.ReloadInterval <= 0 {
+		return nil, fmt.Errorf("%w: reload interval %s is not positive", ErrInvalidConfig, tcfg.ReloadInterval)
+	}
+
+	var err error
+	if r.minVersion, err = ParseVersion(tcfg.MinVersion); err != nil {
+		return nil, err
+	}
+
+	r.loaded = r.stamp()
+	if r.current, err = r.load(); err != nil {
+		return nil, err
+	}
+
+	r.checked = r.now()
+
+	return r, nil
+}
+
+// Enabled reports whether the TCP server uses TLS.
+func (r *Reloader) Enabled() bool {
+	return r.cfg.Enabled
+}
+
+// Config returns the TLS config of the listener, each handshake gets the latest loaded settings.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{MinVersion: r.minVersion, GetConfigForClient: r.configForClient}
+}
+
+// ParseVersion returns the TLS version named "1.2" or "1.3", 1.2 if empty.
+func ParseVersion(version string) (uint16, error) {
+	switch strings.TrimSpace(version) {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("%w: %s", ErrUnknownVersion, version)
+	}
+}
+
+// LoadCertPool returns a pool of the certificates of the PEM file only.
+func LoadCertPool(file string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	if err := AppendCertPool(pool, file); err != nil {
+		return nil, err
+	}
+
+	return pool, nil
+}
+
+// AppendCertPool adds the certificates of the PEM file to the pool.
+func AppendCertPool(pool *x509.CertPool, file string) error {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read CA file failed: %w", err)
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("%w: %s", ErrInvalidCA, file)
+	}
+
+	return nil
+}
+
+func (r *Reloader) configForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if now.Sub(r.checked) < r.cfg.ReloadInterval {
+		return r.current, nil
+	}
+
+	r.checked = now
+
+	// the stamp is taken first, files changing while they are loaded are loaded again next time
+	stamp := r.stamp()
+	if stamp == r.loaded {
+		return r.current, nil
+	}
+
+	// a certificate renewed without its key yet fails to load, it is retried on the next check
+	current, err := r.load()
+	if err != nil {
+		r.logger.Error("reload tls files failed, keeping the previous ones", zap.Error(err))
+
+		return r.current, nil
+	}
+
+	r.logger.Info("tls files reloaded", zap.String("certFile", r.cfg.CertFile))
+	r.current, r.loaded = current, stamp
+
+	return r.current, nil
+}
+
+func (r *Reloader) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate failed: %w", err)
+	}
+
+	tlsCfg := &tls.Config{MinVersion: r.minVersion, Certificates: []tls.Certificate{cert}}
+
+	if r.cfg.ClientCAFile != "" {
+		pool, err := LoadCertPool(r.cfg.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsCfg, nil
+}
+
+// stamp returns the size and modification time of the files, it changes when one of them does.
+func (r *Reloader) stamp() string {
+	var stamp strings.Builder
+
+	for _, file := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			stamp.WriteString(file + ":missing;")
+
+			continue
+		}
+
+		stamp.WriteString(fmt.Sprintf("%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano()))
+	}
+
+	return stamp.String()
+}
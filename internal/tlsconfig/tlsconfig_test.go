@@ -0,0 +1,320 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"zenquote/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// testCA issues the certificates of a test.
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+// issue writes a new certificate and its key to the files, for localhost if it is a server one.
+func (ca *testCA) issue(t *testing.T, certFile string, keyFile string, server bool) *big.Int {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	if server {
+		template.DNSNames = []string{"localhost"}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+
+	return template.SerialNumber
+}
+
+func (ca *testCA) writeCert(t *testing.T, file string) {
+	t.Helper()
+
+	writePEM(t, file, "CERTIFICATE", ca.cert.Raw)
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	return pool
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	t.Helper()
+
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+}
+
+type testFiles struct {
+	dir, cert, key, clientCA string
+}
+
+func newTestFiles(t *testing.T) testFiles {
+	t.Helper()
+
+	dir := t.TempDir()
+
+	return testFiles{
+		dir:      dir,
+		cert:     filepath.Join(dir, "server.crt"),
+		key:      filepath.Join(dir, "server.key"),
+		clientCA: filepath.Join(dir, "ca.crt"),
+	}
+}
+
+func testConfig(files testFiles, clientCA string) config.Config {
+	cfg := config.Config{}
+	cfg.TCP.TLS = config.TLS{
+		Enabled:        true,
+		CertFile:       files.cert,
+		KeyFile:        files.key,
+		MinVersion:     "1.2",
+		ClientCAFile:   clientCA,
+		ReloadInterval: time.Hour,
+	}
+
+	return cfg
+}
+
+// handshake connects a client over loopback and returns the certificate served to it.
+func handshake(t *testing.T, serverCfg *tls.Config, clientCfg *tls.Config) (*x509.Certificate, error) {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverCfg)
+	require.NoError(t, err)
+
+	defer func() {
+		_ = listener.Close()
+	}()
+
+	serverErr := make(chan error, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+
+			return
+		}
+
+		defer func() {
+			_ = conn.Close()
+		}()
+
+		serverErr <- conn.(*tls.Conn).Handshake()
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientCfg)
+	if err != nil {
+		<-serverErr
+
+		return nil, err
+	}
+
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// with TLS 1.3 the client is done before the server has checked its certificate
+	if err = <-serverErr; err != nil {
+		return nil, err
+	}
+
+	return conn.ConnectionState().PeerCertificates[0], nil
+}
+
+func TestHandshake(t *testing.T) {
+	t.Parallel()
+
+	files := newTestFiles(t)
+	ca := newTestCA(t)
+	serial := ca.issue(t, files.cert, files.key, true)
+
+	reloader, err := NewReloader(testConfig(files, ""), zap.NewNop())
+	require.NoError(t, err)
+	assert.True(t, reloader.Enabled())
+
+	cert, err := handshake(t, reloader.Config(), &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    ca.pool(),
+		ServerName: "localhost",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, serial, cert.SerialNumber)
+}
+
+func TestMutualTLS(t *testing.T) {
+	t.Parallel()
+
+	files := newTestFiles(t)
+	ca := newTestCA(t)
+	ca.issue(t, files.cert, files.key, true)
+	ca.writeCert(t, files.clientCA)
+
+	clientCert, clientKey := filepath.Join(files.dir, "client.crt"), filepath.Join(files.dir, "client.key")
+	ca.issue(t, clientCert, clientKey, false)
+
+	reloader, err := NewReloader(testConfig(files, files.clientCA), zap.NewNop())
+	require.NoError(t, err)
+
+	clientCfg := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: ca.pool(), ServerName: "localhost"}
+
+	_, err = handshake(t, reloader.Config(), clientCfg)
+	assert.Error(t, err, "client without a certificate")
+
+	cert, err := tls.LoadX509KeyPair(clientCert, clientKey)
+	require.NoError(t, err)
+
+	clientCfg.Certificates = []tls.Certificate{cert}
+
+	_, err = handshake(t, reloader.Config(), clientCfg)
+	assert.NoError(t, err)
+}
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	files := newTestFiles(t)
+	ca := newTestCA(t)
+	first := ca.issue(t, files.cert, files.key, true)
+
+	cfg := testConfig(files, "")
+	cfg.TCP.TLS.ReloadInterval = time.Minute
+
+	reloader, err := NewReloader(cfg, zap.NewNop())
+	require.NoError(t, err)
+
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+
+	clientCfg := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: ca.pool(), ServerName: "localhost"}
+	served := func() *big.Int {
+		cert, err := handshake(t, reloader.Config(), clientCfg)
+		require.NoError(t, err)
+
+		return cert.SerialNumber
+	}
+
+	// the files are only checked once per reload interval
+	renewed := ca.issue(t, files.cert, files.key, true)
+	touch(t, files.cert, files.key)
+	assert.Equal(t, first, served())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, renewed, served())
+
+	// a broken key keeps the previous certificate
+	require.NoError(t, os.WriteFile(files.key, []byte("broken"), 0o600))
+	touch(t, files.key)
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, renewed, served())
+}
+
+// touch moves the modification time of the files forward, file systems may round it to the second.
+func touch(t *testing.T, files ...string) {
+	t.Helper()
+
+	later := time.Now().Add(time.Hour)
+	for _, file := range files {
+		require.NoError(t, os.Chtimes(file, later, later))
+	}
+}
+
+func TestNewReloader(t *testing.T) {
+	t.Parallel()
+
+	files := newTestFiles(t)
+
+	reloader, err := NewReloader(config.Config{}, zap.NewNop())
+	require.NoError(t, err)
+	assert.False(t, reloader.Enabled())
+
+	_, err = NewReloader(testConfig(files, ""), zap.NewNop())
+	assert.Error(t, err, "missing certificate")
+
+	newTestCA(t).issue(t, files.cert, files.key, true)
+
+	cfg := testConfig(files, "")
+	cfg.TCP.TLS.MinVersion = "1.1"
+
+	_, err = NewReloader(cfg, zap.NewNop())
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+
+	cfg = testConfig(files, files.cert+".missing")
+
+	_, err = NewReloader(cfg, zap.NewNop())
+	assert.Error(t, err, "missing client CA")
+
+	cfg = testConfig(files, "")
+	cfg.TCP.TLS.KeyFile = ""
+
+	_, err = NewReloader(cfg, zap.NewNop())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+
+	cfg = testConfig(files, "")
+	cfg.TCP.TLS.ReloadInterval = 0
+
+	_, err = NewReloader(cfg, zap.NewNop())
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}